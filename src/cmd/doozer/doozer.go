@@ -0,0 +1,116 @@
+package main
+
+import (
+	"doozer/client"
+	"doozer/store"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Flags
+var (
+	addr = flag.String("a", "127.0.0.1:8046", "The address of a doozer node.")
+)
+
+// Paths that belong to a particular cluster's identity and are not restored.
+var skipPrefixes = []string{
+	"/doozer/",
+	"/session/",
+	"/lock/",
+}
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] COMMAND\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	fmt.Fprintf(os.Stderr, "  backup   write a snapshot of the store to stdout\n")
+	fmt.Fprintf(os.Stderr, "  restore  copy a snapshot from stdin into the store\n")
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = Usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	cl, err := client.Dial(*addr)
+	if err != nil {
+		bail(err)
+	}
+
+	switch flag.Arg(0) {
+	case "backup":
+		backup(cl)
+	case "restore":
+		restore(cl)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func bail(err os.Error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func backup(cl *client.Client) {
+	seqn, snap, err := cl.Backup()
+	if err != nil {
+		bail(err)
+	}
+
+	_, err = os.Stdout.WriteString(snap)
+	if err != nil {
+		bail(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "backed up seqn %d\n", seqn)
+}
+
+// Sets every file in the snapshot, except for those under skipPrefixes.
+// Existing files are overwritten. This is meant for seeding a brand-new
+// cluster.
+func restore(cl *client.Client) {
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		bail(err)
+	}
+
+	seqn, g, err := store.DecodeSnapshot(string(b))
+	if err != nil {
+		bail(err)
+	}
+
+	n := 0
+	for ev := range store.MustWalk(g, "/**") {
+		if skip(ev.Path) {
+			continue
+		}
+
+		_, err = cl.Set(ev.Path, ev.Body, store.Clobber)
+		if err != nil {
+			bail(err)
+		}
+		n++
+	}
+
+	fmt.Fprintf(os.Stderr, "restored %d files from seqn %d\n", n, seqn)
+}
+
+func skip(path string) bool {
+	for _, p := range skipPrefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
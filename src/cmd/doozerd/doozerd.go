@@ -2,9 +2,11 @@ package main
 
 import (
 	"doozer"
+	"doozer/server"
 	"doozer/util"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 )
@@ -13,8 +15,13 @@ import (
 var (
 	listenAddr  = flag.String("l", "127.0.0.1:8046", "The address to bind to.")
 	attachAddr  = flag.String("a", "", "The address of another node to attach to.")
+	backupFile  = flag.String("b", "", "Start a new cluster from this backup file.")
 	webAddr     = flag.String("w", ":8080", "Serve web requests on this address.")
 	clusterName = flag.String("c", "local", "The non-empty cluster name.")
+	maxConns    = flag.Int("maxconns", 0, "Most client connections at once (0 for no limit).")
+	maxReqs     = flag.Int("maxreqs", 0, "Most requests in progress per connection (0 for no limit).")
+	maxWatches  = flag.Int("maxwatches", 0, "Most watches per connection (0 for no limit).")
+	writeRate   = flag.Int("writerate", 0, "Most writes per second (0 for no limit).")
 )
 
 func Usage() {
@@ -33,6 +40,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *attachAddr != "" && *backupFile != "" {
+		fmt.Fprintln(os.Stderr, "cannot both attach and start from a backup")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var seed string
+	if *backupFile != "" {
+		b, err := ioutil.ReadFile(*backupFile)
+		if err != nil {
+			panic(err)
+		}
+		seed = string(b)
+	}
+
 	listener, err := net.Listen("tcp", *listenAddr)
 	if err != nil {
 		panic(err)
@@ -51,5 +73,7 @@ func main() {
 		}
 	}
 
-	doozer.Main(*clusterName, *attachAddr, conn, listener, wl)
+	doozer.Limits = server.Limits{*maxConns, *maxReqs, *maxWatches, *writeRate}
+	doozer.LogConf = true
+	doozer.Main(*clusterName, *attachAddr, seed, conn, listener, wl)
 }
@@ -23,5 +23,6 @@ PKGS="
 "
 
 CMDS="
+    doozer
     doozerd
 "
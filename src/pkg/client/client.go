@@ -1,20 +1,34 @@
 package client
 
 import (
+	"bytes"
 	"doozer/proto"
 	"doozer/util"
-	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 var ErrInvalidResponse = os.NewError("invalid response")
 
+// How long past a request's timeout to wait for the server to report it,
+// before giving up on our own.
+const timeoutSlack = 1e9 // ns == 1s
+
+// When the server is busy, we wait busyDelay before trying again, doubling
+// the wait each time, and give up after busyRetries tries.
+const (
+	busyDelay   = 5e7 // ns == 50ms
+	busyRetries = 6
+)
+
 type Client struct {
-	pr *proto.Conn
-	lg *log.Logger
-	lk sync.Mutex
+	pr   *proto.Conn
+	lg   *util.Logger
+	lk   sync.Mutex
+	addr string
 }
 
 func Dial(addr string) (*Client, os.Error) {
@@ -24,7 +38,7 @@ func Dial(addr string) (*Client, os.Error) {
 	}
 	pr := proto.NewConn(c)
 	go pr.ReadResponses()
-	return &Client{pr: pr, lg: util.NewLogger(addr)}, nil
+	return &Client{pr: pr, lg: util.NewLogger("client").With("addr", addr), addr: addr}, nil
 }
 
 // This is a little subtle. We want to follow redirects while still pipelining
@@ -46,40 +60,94 @@ func Dial(addr string) (*Client, os.Error) {
 // continue functioning as it was. Any writes on the old connection will retry,
 // and then they are guaranteed to pick up the new connection. Any reads on the
 // old connection will just succeed directly.
+//
+// If the connection has failed, for instance because the server hung up on
+// us for having too many connections, we dial the same address again.
 func (cl *Client) proto() (*proto.Conn, os.Error) {
 	cl.lk.Lock()
 	defer cl.lk.Unlock()
 
-	if cl.pr.RedirectAddr != "" {
-		conn, err := net.Dial("tcp", "", cl.pr.RedirectAddr)
+	addr := cl.pr.RedirectAddr
+	if addr == "" && cl.pr.Err() != nil {
+		addr = cl.addr
+	}
+
+	if addr != "" {
+		conn, err := net.Dial("tcp", "", addr)
 		if err != nil {
 			return nil, err
 		}
-		cl.lg = util.NewLogger(cl.pr.RedirectAddr)
+		cl.lg = util.NewLogger("client").With("addr", addr)
 		cl.pr = proto.NewConn(conn)
+		cl.addr = addr
 		go cl.pr.ReadResponses()
 	}
 
 	return cl.pr, nil
 }
 
-func (cl *Client) callWithoutRedirect(verb string, args, slot interface{}) os.Error {
+func (cl *Client) callWithoutRedirect(verb string, args, slot interface{}, timeout int64) os.Error {
 	pr, err := cl.proto()
 	if err != nil {
 		return err
 	}
 
-	req, err := pr.SendRequest(verb, args)
+	_, req, err := pr.StartTimedRequest(verb, args, timeout)
 	if err != nil {
 		return err
 	}
 
-	return req.Get(slot)
+	if timeout == 0 {
+		return req.Get(slot)
+	}
+
+	// The server should time out first, but don't depend on it.
+	first := make(chan interface{}, 1)
+	go func() {
+		first <- <-req
+	}()
+
+	select {
+	case data := <-first:
+		if e, ok := data.(os.Error); ok {
+			return e
+		}
+		return proto.Fit(data, slot)
+	case <-time.After(timeout + timeoutSlack):
+	}
+
+	// Keep reading, so the connection isn't held up when the response
+	// does arrive.
+	go func() {
+		for _ = range req {
+		}
+	}()
+	return proto.ErrTimeout
 }
 
-func (cl *Client) call(verb string, data, slot interface{}) (err os.Error) {
-	for err = os.EAGAIN; err == os.EAGAIN; {
-		err = cl.callWithoutRedirect(verb, data, slot)
+func (cl *Client) call(verb string, data, slot interface{}) os.Error {
+	return cl.callTimeout(verb, data, slot, 0)
+}
+
+// Like call, but gives up after `timeout` nanoseconds, if it is nonzero,
+// and returns proto.ErrTimeout. A write that times out may still be
+// applied.
+//
+// Both call and callTimeout back off and try again while the server says it
+// is busy, returning proto.ErrBusy only if it stays busy.
+func (cl *Client) callTimeout(verb string, data, slot interface{}, timeout int64) (err os.Error) {
+	delay := int64(busyDelay)
+	for tries := 0; ; tries++ {
+		for err = os.EAGAIN; err == os.EAGAIN; {
+			err = cl.callWithoutRedirect(verb, data, slot, timeout)
+		}
+
+		if err != proto.ErrBusy || tries+1 >= busyRetries {
+			break
+		}
+
+		time.Sleep(delay)
+		delay *= 2
 	}
 
 	if err != nil {
@@ -89,28 +157,242 @@ func (cl *Client) call(verb string, data, slot interface{}) (err os.Error) {
 	return err
 }
 
+// Like call, but for requests that produce more than one response. The first
+// response is stored in slot; the rest can be read from the returned channel.
+func (cl *Client) stream(verb string, data, slot interface{}) (r proto.Response, err os.Error) {
+	for err = os.EAGAIN; err == os.EAGAIN; {
+		var pr *proto.Conn
+		pr, err = cl.proto()
+		if err != nil {
+			break
+		}
+
+		r, err = pr.SendRequest(verb, data)
+		if err != nil {
+			break
+		}
+
+		err = r.Get(slot)
+	}
+
+	if err != nil {
+		cl.lg.Println(err)
+	}
+
+	return r, err
+}
+
+// A streaming request, such as a watch, that can be stopped.
+//
+// Results arrive on C, which is closed when the stream ends. If the stream
+// ended because of an error, such as a bad pattern, Err returns it.
+type Stream struct {
+	C <-chan *proto.ResWatch
+
+	pr   *proto.Conn
+	id   uint
+	err  os.Error
+	stop chan bool
+	once sync.Once
+}
+
+func (cl *Client) startStream(verb string, data interface{}) (*Stream, os.Error) {
+	pr, err := cl.proto()
+	if err != nil {
+		return nil, err
+	}
+
+	id, r, err := pr.StartRequest(verb, data)
+	if err != nil {
+		cl.lg.Println(err)
+		return nil, err
+	}
+
+	c := make(chan *proto.ResWatch)
+	s := &Stream{C: c, pr: pr, id: id, stop: make(chan bool)}
+	go s.run(r, c)
+	return s, nil
+}
+
+// Reads responses from `r` until the server closes it. Once the stream has
+// been stopped, responses are read and thrown away rather than sent on `c`,
+// so that the connection is never left waiting on us.
+func (s *Stream) run(r proto.Response, c chan *proto.ResWatch) {
+	defer close(c)
+
+	for data := range r {
+		if e, ok := data.(os.Error); ok {
+			s.err = e
+			continue
+		}
+
+		var res proto.ResWatch
+		err := proto.Fit(data, &res)
+		if err != nil {
+			s.err = err
+			continue
+		}
+
+		select {
+		case c <- &res:
+		case <-s.stop:
+		}
+	}
+}
+
+// Returns the error that ended the stream, if any. Only meaningful once C
+// has been closed.
+func (s *Stream) Err() os.Error {
+	return s.err
+}
+
+// Asks the server to end the stream. Any results not yet received from C are
+// discarded, and C is closed once the server has stopped sending.
+func (s *Stream) Stop() os.Error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	return s.pr.Cancel(s.id)
+}
+
+// Sends each change to a file matching `glob` from now on. See store.Watch
+// for the glob syntax.
+func (cl *Client) Watch(glob string) (*Stream, os.Error) {
+	return cl.startStream("WATCH", glob)
+}
+
+// Like Watch, but sends only the changes selected by `f`. See store.Filter.
+func (cl *Client) WatchFilter(f proto.ReqWatch) (*Stream, os.Error) {
+	return cl.startStream("WATCH", f)
+}
+
+// Sends the current contents of each file matching `glob`, then ends.
+func (cl *Client) Walk(glob string) (*Stream, os.Error) {
+	return cl.startStream("WALK", glob)
+}
+
+// Reads a snapshot sent as a ResSnapshot header followed by chunks.
+func (cl *Client) snapshot(verb string, data interface{}) (seqn uint64, snapshot string, err os.Error) {
+	var res proto.ResSnapshot
+	r, err := cl.stream(verb, data, &res)
+	if err != nil {
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, res.Len))
+	for data := range r {
+		if e, ok := data.(os.Error); ok {
+			return 0, "", e
+		}
+
+		var chunk string
+		err = proto.Fit(data, &chunk)
+		if err != nil {
+			return 0, "", err
+		}
+		buf.WriteString(chunk)
+	}
+
+	if buf.Len() != res.Len {
+		return 0, "", ErrInvalidResponse
+	}
+
+	return res.Seqn, buf.String(), nil
+}
+
+// Joins the cluster as member `id` at `addr`. Servers that don't know JOIN
+// are asked with the older "join".
 func (cl *Client) Join(id, addr string) (seqn uint64, snapshot string, err os.Error) {
+	seqn, snapshot, err = cl.snapshot("JOIN", proto.ReqJoin{id, addr})
+	if e, ok := err.(proto.ResponseError); !ok || !strings.HasPrefix(string(e), proto.InvalidCommand) {
+		return seqn, snapshot, err
+	}
+
 	var res proto.ResJoin
 	err = cl.call("join", proto.ReqJoin{id, addr}, &res)
 	if err != nil {
-		return
+		return 0, "", err
 	}
-
 	return res.Seqn, res.Snapshot, nil
 }
 
+// Returns a snapshot of the entire store, suitable for writing to a backup
+// file. See doc/snapshot.md for its format.
+func (cl *Client) Backup() (seqn uint64, snapshot string, err os.Error) {
+	return cl.snapshot("BACKUP", nil)
+}
+
 func (cl *Client) Set(path, body, oldCas string) (newCas string, err os.Error) {
-	err = cl.call("SET", proto.ReqSet{path, body, oldCas}, &newCas)
+	return cl.SetTimeout(path, body, oldCas, 0)
+}
+
+// Like Set, but gives up after `timeout` nanoseconds and returns
+// proto.ErrTimeout. The set may still happen after that. A timeout of 0
+// means no limit. The other ...Timeout methods work the same way.
+func (cl *Client) SetTimeout(path, body, oldCas string, timeout int64) (newCas string, err os.Error) {
+	err = cl.callTimeout("SET", proto.ReqSet{path, body, oldCas}, &newCas, timeout)
 	return
 }
 
 func (cl *Client) Del(path, cas string) os.Error {
-	return cl.call("DEL", proto.ReqDel{path, cas}, nil)
+	return cl.DelTimeout(path, cas, 0)
+}
+
+func (cl *Client) DelTimeout(path, cas string, timeout int64) os.Error {
+	return cl.callTimeout("DEL", proto.ReqDel{path, cas}, nil, timeout)
+}
+
+// Deletes `path` and everything below it. If `path` is a directory, `cas`
+// is compared to the CAS token of its newest descendant.
+func (cl *Client) DelTree(path, cas string) os.Error {
+	return cl.DelTreeTimeout(path, cas, 0)
+}
+
+func (cl *Client) DelTreeTimeout(path, cas string, timeout int64) os.Error {
+	return cl.callTimeout("DELTREE", proto.ReqDel{path, cas}, nil, timeout)
+}
+
+// Atomically moves `from` and everything below it to `to`, which must not
+// exist. `cas` is compared as for DelTree.
+func (cl *Client) Move(from, to, cas string) os.Error {
+	return cl.MoveTimeout(from, to, cas, 0)
+}
+
+func (cl *Client) MoveTimeout(from, to, cas string, timeout int64) os.Error {
+	return cl.callTimeout("MOVE", proto.ReqMove{from, to, cas}, nil, timeout)
 }
 
 func (cl *Client) Noop() os.Error {
+	return cl.NoopTimeout(0)
+}
+
+func (cl *Client) NoopTimeout(timeout int64) os.Error {
 	var res string
-	return cl.call("NOOP", nil, &res)
+	return cl.callTimeout("NOOP", nil, &res, timeout)
+}
+
+// Returns metadata for the file or directory at `path`. If there is no such
+// path, the returned Cas is "0".
+func (cl *Client) Stat(path string) (*proto.ResStat, os.Error) {
+	var res proto.ResStat
+	err := cl.call("STAT", path, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// Returns the versions of `path` recorded by the server, oldest first. See
+// store.HistoryPath for how to configure what is recorded.
+func (cl *Client) History(path string) ([]proto.ResVersion, os.Error) {
+	var res []proto.ResVersion
+	err := cl.call("HISTORY", path, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
 }
 
 func (cl *Client) Checkin(id, cas string) (int64, string, os.Error) {
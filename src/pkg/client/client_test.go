@@ -1,6 +1,62 @@
 package client
 
-import "testing"
+import (
+	"doozer/proto"
+	"doozer/store"
+	"github.com/bmizerany/assert"
+	"net"
+	"os"
+	"testing"
+)
 
 func TestFoo(t *testing.T) {
 }
+
+// Answers requests as a server from before JOIN would, with the snapshot of
+// `st` in the legacy form.
+func serveOldJoin(l net.Listener, st *store.Store) {
+	c, err := l.Accept()
+	if err != nil {
+		return
+	}
+	pr := proto.NewConn(c)
+	defer pr.Close()
+
+	for {
+		rid, verb, _, err := pr.ReadRequest()
+		if err != nil {
+			return
+		}
+
+		switch verb {
+		case "join":
+			seqn, snap := st.LegacySnapshot()
+			pr.SendResponse(rid, proto.Last, proto.ResJoin{seqn, snap})
+		default:
+			pr.SendResponse(rid, proto.Last, os.ErrorString(proto.InvalidCommand+" "+verb))
+		}
+	}
+}
+
+func TestJoinOldServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer l.Close()
+
+	old := store.New()
+	old.Ops <- store.Op{1, store.MustEncodeSet("/x", "a", store.Clobber)}
+	old.Sync(1)
+	go serveOldJoin(l, old)
+
+	cl, err := Dial(l.Addr().String())
+	assert.Equal(t, nil, err)
+	seqn, snap, err := cl.Join("b", "1.2.3.4:5")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), seqn)
+	assert.Equal(t, nil, store.CheckSnapshot(snap))
+
+	st := store.New()
+	st.Ops <- store.Op{1, snap}
+	st.Sync(1)
+	assert.Equal(t, "a", store.GetString(st, "/x"))
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"doozer/proto"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Timer is a one-shot timer kept in the cluster at Path, whose body is the
+// time it is due, in ns. Any client may set it again, with Sett or Reset, or
+// cancel it by deleting Path.
+//
+// When it comes due, each client watching it tries to delete Path. The one
+// that succeeds receives the time on C, so the timer fires once in the whole
+// cluster.
+type Timer struct {
+	Path string
+	C    <-chan int64
+
+	cl   *Client
+	s    *Stream
+	stop chan bool
+	once sync.Once
+}
+
+// Sets the timer at `path` to go off `n` ns from now, and watches it.
+func (cl *Client) NewTimer(path string, n int64) (*Timer, os.Error) {
+	t, err := cl.WatchTimer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.Reset(n)
+	if err != nil {
+		t.Stop()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Watches the timer at `path`, which may be set already.
+func (cl *Client) WatchTimer(path string) (*Timer, os.Error) {
+	s, err := cl.Watch(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk after starting the watch, so no change is missed in between.
+	w, err := cl.Walk(path)
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+
+	c := make(chan int64)
+	t := &Timer{Path: path, C: c, cl: cl, s: s, stop: make(chan bool)}
+	go t.run(c, w)
+	return t, nil
+}
+
+func (t *Timer) run(c chan int64, w *Stream) {
+	defer close(c)
+
+	var at int64
+	var cas string
+	due := make(chan string) // receives the cas of a setting when it is due
+
+	set := func(ev *proto.ResWatch) {
+		n, err := strconv.Atoi64(ev.Body)
+		if err != nil {
+			at, cas = 0, "" // deleted, or not a time
+			return
+		}
+
+		at, cas = n, ev.Cas
+		go func() {
+			time.Sleep(n - time.Nanoseconds())
+			select {
+			case due <- ev.Cas:
+			case <-t.stop:
+			}
+		}()
+	}
+
+	// Read the watch during the walk too, so that neither stream holds up
+	// the connection, but apply its events only once the walk is done. They
+	// may be older than the walk; replayed in order, they still end at the
+	// latest setting.
+	var early []*proto.ResWatch
+	for walk := w.C; walk != nil; {
+		select {
+		case ev := <-walk:
+			if closed(walk) {
+				walk = nil
+				break
+			}
+			set(ev)
+		case ev := <-t.s.C:
+			if closed(t.s.C) {
+				w.Stop()
+				return
+			}
+			early = append(early, ev)
+		case <-t.stop:
+			w.Stop()
+			return
+		}
+	}
+
+	for _, ev := range early {
+		set(ev)
+	}
+
+	for {
+		select {
+		case ev := <-t.s.C:
+			if closed(t.s.C) {
+				return
+			}
+			set(ev)
+		case dcas := <-due:
+			if dcas != cas {
+				break // set again or deleted since
+			}
+
+			fired := at
+			at, cas = 0, ""
+			if t.cl.Del(t.Path, dcas) != nil {
+				break // another client got it, or it changed
+			}
+
+			select {
+			case c <- fired:
+			case <-t.stop:
+				return
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Sets the timer to go off `n` ns from now, replacing any earlier setting.
+func (t *Timer) Reset(n int64) os.Error {
+	_, _, err := t.cl.Sett(t.Path, n, "")
+	return err
+}
+
+// Cancels the timer everywhere, by deleting its path.
+func (t *Timer) Cancel() os.Error {
+	return t.cl.Del(t.Path, "")
+}
+
+// Stops watching the timer. It is not cancelled; other clients may still
+// fire it. C is closed.
+func (t *Timer) Stop() os.Error {
+	t.once.Do(func() {
+		close(t.stop)
+	})
+	return t.s.Stop()
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"doozer/proto"
+	"github.com/bmizerany/assert"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Serves a timer at /t, as a busy server might: the watch sends several
+// changes before the walk sends anything. The last change makes the timer
+// due at `at`.
+func serveBusyTimer(l net.Listener, at int64) {
+	c, err := l.Accept()
+	if err != nil {
+		return
+	}
+	pr := proto.NewConn(c)
+	defer pr.Close()
+
+	var watchId uint
+	for {
+		rid, verb, data, err := pr.ReadRequest()
+		if err != nil {
+			return
+		}
+
+		switch verb {
+		case "WATCH":
+			watchId = rid
+		case "WALK":
+			for i := int64(1); i <= 3; i++ {
+				body := strconv.Itoa64(at + (3-i)*1e9)
+				pr.SendResponse(watchId, 0, proto.ResWatch{"/t", body, strconv.Itoa64(i + 1)})
+			}
+			pr.SendResponse(rid, 0, proto.ResWatch{"/t", "", "1"})
+			pr.SendResponse(rid, proto.Closed, nil)
+		case "STAT":
+			pr.SendResponse(rid, proto.Last, proto.ResStat{Cas: "4"})
+		case "DEL":
+			pr.SendResponse(rid, proto.Last, nil)
+		case "CLOSE":
+			var id uint
+			proto.Fit(data, &id)
+			pr.SendResponse(id, proto.Closed, nil)
+			pr.SendResponse(rid, proto.Last, nil)
+		}
+	}
+}
+
+func TestTimerWatchDuringWalk(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer l.Close()
+
+	at := time.Nanoseconds() + 5e7
+	go serveBusyTimer(l, at)
+
+	cl, err := Dial(l.Addr().String())
+	assert.Equal(t, nil, err)
+	tm, err := cl.WatchTimer("/t")
+	assert.Equal(t, nil, err)
+
+	// The timer does not hold up other requests on the connection.
+	st, err := cl.Stat("/t")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "4", st.Cas)
+
+	// It goes off at the latest setting.
+	assert.Equal(t, at, <-tm.C)
+
+	assert.Equal(t, nil, tm.Stop())
+	for _ = range tm.C {
+	}
+}
@@ -16,13 +16,33 @@ import (
 	"time"
 )
 
+// Paths that describe the membership of a cluster. See reset.
+var resetGlobs = []string{
+	"/doozer/info/**",
+	"/doozer/members/*",
+	"/doozer/slot/*",
+	"/doozer/leader",
+}
+
+// Limits on what clients may do. See server.Limits. Set this before calling
+// Main.
+var Limits server.Limits
+
+// If true, Main follows the log levels set under logConfDir for as long as
+// the process runs. Set this before calling Main.
+var LogConf bool
+
 const (
 	alpha           = 50
 	checkinInterval = 1e9 // ns == 1s
 	pulseInterval   = 1e9
 )
 
-func Main(clusterName, attachAddr string, udpConn net.PacketConn, listener, webListener net.Listener) {
+// Starts a doozer node. If attachAddr is empty, the node forms a new cluster
+// by itself; if seed is also non-empty, it must be a snapshot, and the new
+// cluster's store is preloaded from it. Otherwise the node joins the cluster
+// at attachAddr.
+func Main(clusterName, attachAddr, seed string, udpConn net.PacketConn, listener, webListener net.Listener) {
 	logger := util.NewLogger("main")
 
 	var err os.Error
@@ -37,12 +57,23 @@ func Main(clusterName, attachAddr string, udpConn net.PacketConn, listener, webL
 	self := util.RandId()
 	st := store.New()
 	if attachAddr == "" { // we are the only node in a new cluster
+		if seed != "" {
+			err = store.CheckSnapshot(seed)
+			if err != nil {
+				panic(err)
+			}
+
+			st.Ops <- store.Op{1, seed}
+			st.Sync(1)
+			reset(st)
+		}
+
 		set(st, "/doozer/info/"+self+"/public-addr", listenAddr, store.Missing)
 		set(st, "/doozer/info/"+self+"/hostname", os.Getenv("HOSTNAME"), store.Missing)
 		set(st, "/doozer/members/"+self, listenAddr, store.Missing)
 		set(st, "/doozer/slot/"+"1", self, store.Missing)
 		set(st, "/doozer/leader", self, store.Missing)
-		set(st, "/ping", "pong", store.Missing)
+		set(st, "/ping", "pong", store.Clobber)
 
 		close(cal)
 
@@ -73,6 +104,11 @@ func Main(clusterName, attachAddr string, udpConn net.PacketConn, listener, webL
 			panic(err)
 		}
 
+		err = store.CheckSnapshot(snap)
+		if err != nil {
+			panic(err)
+		}
+
 		done := make(chan int)
 		st.Ops <- store.Op{1, snap}
 
@@ -90,6 +126,10 @@ func Main(clusterName, attachAddr string, udpConn net.PacketConn, listener, webL
 
 	mg := paxos.NewManager(self, alpha, st, outs)
 
+	if LogConf {
+		go watchLogConf(st)
+	}
+
 	if attachAddr == "" {
 		// Skip ahead alpha steps so that the registrar can provide a
 		// meaningful cluster.
@@ -108,7 +148,14 @@ func Main(clusterName, attachAddr string, udpConn net.PacketConn, listener, webL
 		go gc.Clean(st)
 	}()
 
-	sv := &server.Server{udpConn, listenAddr, st, mg, self}
+	sv := &server.Server{
+		Conn:   udpConn,
+		Addr:   listenAddr,
+		St:     st,
+		Mg:     mg,
+		Self:   self,
+		Limits: Limits,
+	}
 
 	go func() {
 		cas := store.Missing
@@ -139,7 +186,11 @@ func Main(clusterName, attachAddr string, udpConn net.PacketConn, listener, webL
 func activate(st *store.Store, self string, c *client.Client, cal chan int) {
 	logger := util.NewLogger("activate")
 	ch := make(chan store.Event)
-	st.GetDirAndWatch("/doozer/slot", ch)
+	err := st.GetDirAndWatch("/doozer/slot", ch)
+	if err != nil {
+		panic(err)
+	}
+
 	for ev := range ch {
 		// TODO ev.IsEmpty()
 		if ev.IsSet() && ev.Body == "" {
@@ -160,6 +211,23 @@ func advanceUntil(cl *client.Client, done chan int) {
 	}
 }
 
+// Removes the identity of the cluster a seed snapshot was taken from, so that
+// the new cluster can record its own. Sessions and locks are left alone; they
+// will expire and be cleaned up as usual.
+func reset(st *store.Store) {
+	g := st.Snap()
+	for _, glob := range resetGlobs {
+		for ev := range store.MustWalk(g, glob) {
+			del(st, ev.Path, ev.Cas)
+		}
+	}
+}
+
+func del(st *store.Store, path, cas string) {
+	mut := store.MustEncodeDel(path, cas)
+	st.Ops <- store.Op{1 + <-st.Seqns, mut}
+}
+
 func set(st *store.Store, path, body, cas string) {
 	mut := store.MustEncodeSet(path, body, cas)
 	st.Ops <- store.Op{1 + <-st.Seqns, mut}
@@ -2,6 +2,8 @@ package doozer
 
 import (
 	"doozer/client"
+	"doozer/proto"
+	"doozer/store"
 	"github.com/bmizerany/assert"
 	"net"
 	"runtime"
@@ -34,13 +36,181 @@ func TestDoozerSimple(t *testing.T) {
 	u := mustListenPacket(l.Addr().String())
 	defer u.Close()
 
-	go Main("a", "", u, l, nil)
+	go Main("a", "", "", u, l, nil)
 
 	cl, err := client.Dial(l.Addr().String())
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, cl.Noop())
 }
 
+func TestDoozerSeed(t *testing.T) {
+	old := store.New()
+	old.Ops <- store.Op{1, store.MustEncodeSet("/x", "a", store.Clobber)}
+	old.Ops <- store.Op{2, store.MustEncodeSet("/doozer/members/abc", "x", store.Clobber)}
+	old.Sync(2)
+	_, seed := old.Snapshot()
+
+	l := mustListen()
+	defer l.Close()
+	u := mustListenPacket(l.Addr().String())
+	defer u.Close()
+
+	go Main("a", "", seed, u, l, nil)
+
+	cl, err := client.Dial(l.Addr().String())
+	assert.Equal(t, nil, err)
+
+	// the cas token survives the restore
+	_, err = cl.Set("/x", "b", "1")
+	assert.Equal(t, nil, err)
+
+	// the old cluster's members do not
+	_, err = cl.Set("/doozer/members/abc", "y", store.Missing)
+	assert.Equal(t, nil, err)
+}
+
+func TestDoozerOldJoin(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+	u := mustListenPacket(l.Addr().String())
+	defer u.Close()
+
+	go Main("a", "", "", u, l, nil)
+
+	// Join as a node from before JOIN would.
+	c, err := net.Dial("tcp", "", l.Addr().String())
+	assert.Equal(t, nil, err)
+	pr := proto.NewConn(c)
+	go pr.ReadResponses()
+	defer pr.Close()
+
+	r, err := pr.SendRequest("join", proto.ReqJoin{"b", "1.2.3.4:5"})
+	assert.Equal(t, nil, err)
+	var res proto.ResJoin
+	assert.Equal(t, nil, r.Get(&res))
+	assert.T(t, !store.IsSnapshot(res.Snapshot))
+
+	st := store.New()
+	st.Ops <- store.Op{1, res.Snapshot}
+	st.Sync(res.Seqn)
+	assert.Equal(t, "pong", store.GetString(st, "/ping"))
+	assert.Equal(t, "1.2.3.4:5", store.GetString(st, "/doozer/members/b"))
+}
+
+func TestDoozerWatchStop(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+	u := mustListenPacket(l.Addr().String())
+	defer u.Close()
+
+	go Main("a", "", "", u, l, nil)
+
+	cl, err := client.Dial(l.Addr().String())
+	assert.Equal(t, nil, err)
+
+	w, err := cl.Watch("/x/**")
+	assert.Equal(t, nil, err)
+
+	_, err = cl.Set("/x/a", "1", store.Clobber)
+	assert.Equal(t, nil, err)
+
+	ev := <-w.C
+	assert.Equal(t, "/x/a", ev.Path)
+	assert.Equal(t, "1", ev.Body)
+
+	assert.Equal(t, nil, w.Stop())
+	for _ = range w.C {
+	}
+	assert.Equal(t, nil, w.Err())
+
+	// The connection still works.
+	assert.Equal(t, nil, cl.Noop())
+}
+
+func TestDoozerWatchBadPattern(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+	u := mustListenPacket(l.Addr().String())
+	defer u.Close()
+
+	go Main("a", "", "", u, l, nil)
+
+	cl, err := client.Dial(l.Addr().String())
+	assert.Equal(t, nil, err)
+
+	w, err := cl.Watch("x")
+	assert.Equal(t, nil, err)
+	for _ = range w.C {
+	}
+	assert.NotEqual(t, nil, w.Err())
+}
+
+func TestDoozerWalk(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+	u := mustListenPacket(l.Addr().String())
+	defer u.Close()
+
+	go Main("a", "", "", u, l, nil)
+
+	cl, err := client.Dial(l.Addr().String())
+	assert.Equal(t, nil, err)
+
+	_, err = cl.Set("/x/a", "1", store.Clobber)
+	assert.Equal(t, nil, err)
+	_, err = cl.Set("/x/b/c", "2", store.Clobber)
+	assert.Equal(t, nil, err)
+
+	w, err := cl.Walk("/x/**")
+	assert.Equal(t, nil, err)
+
+	got := make(map[string]string)
+	for ev := range w.C {
+		got[ev.Path] = ev.Body
+	}
+	assert.Equal(t, nil, w.Err())
+	assert.Equal(t, map[string]string{"/x/a": "1", "/x/b/c": "2"}, got)
+}
+
+func TestDoozerTimer(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+	u := mustListenPacket(l.Addr().String())
+	defer u.Close()
+
+	go Main("a", "", "", u, l, nil)
+
+	cl, err := client.Dial(l.Addr().String())
+	assert.Equal(t, nil, err)
+
+	a, err := cl.NewTimer("/x/t", 5e7)
+	assert.Equal(t, nil, err)
+	b, err := cl.WatchTimer("/x/t")
+	assert.Equal(t, nil, err)
+
+	// It fires once, for one of the two.
+	var at int64
+	select {
+	case at = <-a.C:
+	case at = <-b.C:
+	}
+	assert.NotEqual(t, int64(0), at)
+
+	st, err := cl.Stat("/x/t")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, store.Missing, st.Cas)
+
+	// A cancelled timer does not fire.
+	assert.Equal(t, nil, a.Reset(5e7))
+	assert.Equal(t, nil, a.Cancel())
+	assert.Equal(t, nil, a.Stop())
+	assert.Equal(t, nil, b.Stop())
+	for _ = range a.C {
+	}
+	for _ = range b.C {
+	}
+}
+
 func TestGoroutines(t *testing.T) {
 	gs := runtime.Goroutines()
 
@@ -50,7 +220,7 @@ func TestGoroutines(t *testing.T) {
 		u := mustListenPacket(l.Addr().String())
 		defer u.Close()
 
-		go Main("a", "", u, l, nil)
+		go Main("a", "", "", u, l, nil)
 
 		cl, err := client.Dial(l.Addr().String())
 		assert.Equal(t, nil, err)
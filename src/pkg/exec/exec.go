@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -14,6 +15,10 @@ const (
 	inputReadFd        = 3
 	statusWriteFd      = 4
 	passListenFdsStart = 5
+
+	// Where the service finds its listen fds, as in systemd's
+	// sd_listen_fds(3).
+	listenFdsStart = 3
 )
 
 const cookie = "5561f7ed5c1a1406811f68fa54bdb597" // arbitrary
@@ -43,11 +48,21 @@ type Context struct {
 	WorkingDirectory string
 	User, Group      string
 	Nice             int
+	Env              []string // "name=value", added to the environment
+	Rlimits          []Rlimit
+	Umask            int
+	SetUmask         bool // if false, Umask is ignored and the umask left alone
+}
+
+// A resource limit, as for setrlimit(2).
+type Rlimit struct {
+	Resource int // such as syscall.RLIMIT_NOFILE
+	Cur, Max uint64
 }
 
 type run struct {
 	Context
-	cmd string
+	Cmd string
 }
 
 func hasProc() bool {
@@ -58,6 +73,12 @@ func hasProc() bool {
 // ForkExec runs `cmd` in a child process, with all configuration options as
 // specified in `cx` and the listen fds `lf` in its initial set of fds.
 func (cx *Context) ForkExec(cmd string, lf []*os.File) (pid int, err os.Error) {
+	return cx.ForkExecOutput(cmd, lf, os.Stdout, os.Stderr)
+}
+
+// ForkExecOutput is like ForkExec, but the child's standard output and
+// standard error go to `stdout` and `stderr`.
+func (cx *Context) ForkExecOutput(cmd string, lf []*os.File, stdout, stderr *os.File) (pid int, err os.Error) {
 	// This is not easy to do, because Go does not give us a plain fork
 	// function. Here's the trick: we fork/exec the same program currently
 	// running in this process, then send configuration parameters to it over a
@@ -76,7 +97,7 @@ func (cx *Context) ForkExec(cmd string, lf []*os.File) (pid int, err os.Error) {
 
 	var r run
 	r.Context = *cx
-	r.cmd = cmd
+	r.Cmd = cmd
 
 	var ir, iw, sr, sw *os.File
 	sb := make([]byte, 4)
@@ -100,8 +121,8 @@ func (cx *Context) ForkExec(cmd string, lf []*os.File) (pid int, err os.Error) {
 	// Boring, ugly code to set up the list of child fds.
 	files := make([]*os.File, passListenFdsStart+len(lf))
 	files[0] = os.Stdin
-	files[1] = os.Stdout
-	files[2] = os.Stderr
+	files[1] = stdout
+	files[2] = stderr
 	files[inputReadFd] = ir
 	files[statusWriteFd] = sw
 	copy(files[passListenFdsStart:], lf)
@@ -189,16 +210,20 @@ func execInChild() {
 	// paranoid.)
 	syscall.ForkLock.RLock()
 
-	// In case we have any listen fds, move statusWriteFd and inputReadFd out
-	// of the way, so we can shift down all the listen fds.
-	swfd, e1 := syscall.Dup(statusWriteFd)
+	// How many (if any) listen fds are we giving to the child?
+	nListenFds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+
+	// Move statusWriteFd and inputReadFd above all the listen fds, so that
+	// shifting the listen fds down can't land on them.
+	top := passListenFdsStart + nListenFds
+	swfd, e1 := dupAbove(statusWriteFd, top)
 	if e1 != 0 {
 		panicChild(os.NewFile(statusWriteFd, "|status"), e1)
 	}
 	sw = os.NewFile(swfd, "|status")
 	syscall.Close(statusWriteFd)
 
-	irfd, e1 := syscall.Dup(inputReadFd)
+	irfd, e1 := dupAbove(inputReadFd, top)
 	if e1 != 0 {
 		panicChild(sw, e1)
 	}
@@ -211,16 +236,14 @@ func execInChild() {
 	syscall.CloseOnExec(swfd)
 	syscall.CloseOnExec(irfd)
 
-	// How many (if any) listen fds are we giving to the child?
-	nListenFds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
-
-	// Shift down all the listen fds from starting at 5 to start at 3.
+	// Shift down all the listen fds from passListenFdsStart to
+	// listenFdsStart.
 	for i := 0; i < nListenFds; i++ {
 		// First mark the old one close-on-exec
-		syscall.CloseOnExec(i + 5)
+		syscall.CloseOnExec(passListenFdsStart + i)
 
-		// Now make the new fd (i+3); it is NOT close-on-exec.
-		e1 = dup2(i+5, i+3)
+		// Now make the new fd; it is NOT close-on-exec.
+		e1 = dup2(passListenFdsStart+i, listenFdsStart+i)
 		if e1 != 0 {
 			panicChild(sw, e1)
 		}
@@ -240,13 +263,54 @@ func execInChild() {
 		panicChild(sw, syscall.EINVAL)
 	}
 
+	if r.SetUmask {
+		syscall.Umask(r.Umask)
+	}
+
+	for _, rl := range r.Rlimits {
+		e1 = syscall.Setrlimit(rl.Resource, &syscall.Rlimit{rl.Cur, rl.Max})
+		if e1 != 0 {
+			panicChild(sw, e1)
+		}
+	}
+
 	// Finally, exec the real program. If Exec returns an error, such as
 	// file-not-found, we send that back to the parent before exiting.
-	envv := os.Environ()
-	e1 = syscall.Exec(r.cmd, []string{r.cmd}, envv)
+	envv := mergeEnv(os.Environ(), r.Env)
+	e1 = syscall.Exec(r.Cmd, []string{r.Cmd}, envv)
 	panicChild(sw, e1)
 }
 
+// Duplicates `fd` as the lowest free fd no less than `min`, as F_DUPFD does.
+func dupAbove(fd, min int) (nfd int, errno int) {
+	r, _, e := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_DUPFD, uintptr(min))
+	return int(r), int(e)
+}
+
+// Returns `env` with the variables in `extra` added, replacing any of the
+// same name.
+func mergeEnv(env, extra []string) []string {
+	name := func(kv string) string {
+		if i := strings.Index(kv, "="); i >= 0 {
+			return kv[0:i]
+		}
+		return kv
+	}
+
+	replaced := make(map[string]bool)
+	for _, kv := range extra {
+		replaced[name(kv)] = true
+	}
+
+	var merged []string
+	for _, kv := range env {
+		if !replaced[name(kv)] {
+			merged = append(merged, kv)
+		}
+	}
+	return append(merged, extra...)
+}
+
 // Encode the errno (big-endian) and write it to the status pipe.
 func panicChild(w io.Writer, errno int) {
 	b := []byte{
@@ -58,3 +58,96 @@ func TestLF(t *testing.T) {
 	assert.Equal(t, true, w.Exited())
 	assert.Equal(t, 0, w.ExitStatus())
 }
+
+func TestManyLF(t *testing.T) {
+	if !hasProc() {
+		return
+	}
+
+	cx := Context{}
+	var lf, rs []*os.File
+	for i := 0; i < 3; i++ {
+		pr, pw, err := os.Pipe()
+		assert.Equal(t, nil, err)
+		rs = append(rs, pr)
+		lf = append(lf, pw)
+	}
+
+	pid, err := cx.ForkExec("./test-lfs.sh", lf)
+	assert.Equal(t, nil, err)
+	assert.T(t, pid > 0)
+
+	for _, f := range lf {
+		f.Close()
+	}
+
+	for i, pr := range rs {
+		line, err := ioutil.ReadAll(pr)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []byte{byte('a' + i)}, line)
+	}
+
+	w, err := os.Wait(pid, 0)
+	assert.Equal(t, true, w.Exited())
+	assert.Equal(t, 0, w.ExitStatus())
+}
+
+func TestOutput(t *testing.T) {
+	if !hasProc() {
+		return
+	}
+
+	cx := Context{}
+	or, ow, err := os.Pipe()
+	assert.Equal(t, nil, err)
+	er, ew, err := os.Pipe()
+	assert.Equal(t, nil, err)
+
+	pid, err := cx.ForkExecOutput("./test-output.sh", nil, ow, ew)
+	assert.Equal(t, nil, err)
+	assert.T(t, pid > 0)
+
+	ow.Close()
+	ew.Close()
+
+	out, err := ioutil.ReadAll(or)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{'a'}, out)
+
+	out, err = ioutil.ReadAll(er)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{'b'}, out)
+
+	w, err := os.Wait(pid, 0)
+	assert.Equal(t, true, w.Exited())
+	assert.Equal(t, 0, w.ExitStatus())
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := []string{"A=1", "B=2", "C=3"}
+	exp := []string{"A=1", "C=3", "B=x", "D=y"}
+	assert.Equal(t, exp, mergeEnv(env, []string{"B=x", "D=y"}))
+}
+
+func TestEnvAndUmask(t *testing.T) {
+	if !hasProc() {
+		return
+	}
+
+	for umask, exp := range map[int]string{027: "0027", 0: "0000"} {
+		cx := Context{Env: []string{"DOOZER_TEST=hello"}, Umask: umask, SetUmask: true}
+		or, ow, err := os.Pipe()
+		assert.Equal(t, nil, err)
+
+		pid, err := cx.ForkExecOutput("./test-env.sh", nil, ow, os.Stderr)
+		assert.Equal(t, nil, err)
+		ow.Close()
+
+		out, err := ioutil.ReadAll(or)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "hello "+exp, string(out))
+
+		w, err := os.Wait(pid, 0)
+		assert.Equal(t, 0, w.ExitStatus())
+	}
+}
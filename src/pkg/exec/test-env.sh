@@ -0,0 +1,3 @@
+#!/bin/sh
+
+printf "%s %s" "$DOOZER_TEST" "$(umask)"
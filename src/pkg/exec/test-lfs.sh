@@ -0,0 +1,5 @@
+#!/bin/sh
+
+printf a >&3
+printf b >&4
+printf c >&5
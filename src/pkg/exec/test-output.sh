@@ -0,0 +1,4 @@
+#!/bin/sh
+
+printf a
+printf b >&2
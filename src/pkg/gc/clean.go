@@ -3,7 +3,6 @@ package gc
 import (
 	"doozer/store"
 	"doozer/util"
-	"log"
 	"strings"
 	"strconv"
 )
@@ -11,7 +10,7 @@ import (
 type cleaner struct {
 	st     *store.Store
 	table  map[string]uint64
-	logger *log.Logger
+	logger *util.Logger
 }
 
 func Clean(st *store.Store) {
@@ -21,7 +20,7 @@ func Clean(st *store.Store) {
 		logger: util.NewLogger("clean"),
 	}
 
-	for ev := range st.Watch("/doozer/info/*/applied") {
+	for ev := range st.MustWatch("/doozer/info/*/applied") {
 		cl.update(ev)
 		cl.check()
 	}
@@ -18,7 +18,7 @@ func TestGcClean(t *testing.T) {
 	st.Ops <- store.Op{3, store.MustEncodeSet("/doozer/info/a/applied", "2", store.Missing)}
 
 	st.Ops <- store.Op{4, store.MustEncodeSet("/doozer/info/X/applied", "0", store.Missing)}
-	ch := st.Watch("/x")
+	ch := st.MustWatch("/x")
 	st.Ops <- store.Op{5, store.MustEncodeSet("/x", "", store.Missing)}
 	<-ch
 	close(ch)
@@ -9,7 +9,7 @@ import (
 
 func Clean(st *store.Store, pp paxos.Proposer) {
 	logger := util.NewLogger("lock")
-	for ev := range st.Watch("/session/*") {
+	for ev := range st.MustWatch("/session/*") {
 		if !ev.IsDel() {
 			continue
 		}
@@ -25,7 +25,7 @@ func Clean(st *store.Store, pp paxos.Proposer) {
 
 		for ev := range ch {
 			if ev.Body == name {
-				paxos.Del(pp, ev.Path, ev.Cas)
+				paxos.Del(pp, ev.Path, ev.Cas, nil)
 			}
 		}
 	}
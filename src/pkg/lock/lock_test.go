@@ -14,18 +14,18 @@ func TestLockSimple(t *testing.T) {
 	go Clean(fp.Store, fp)
 
 	// start our session
-	fp.Propose(store.MustEncodeSet("/session/a", "1.2.3.4:55", store.Clobber))
+	fp.Propose(store.MustEncodeSet("/session/a", "1.2.3.4:55", store.Clobber), nil)
 
 	// lock something for a
-	fp.Propose(store.MustEncodeSet("/lock/x", "a", store.Missing))
-	fp.Propose(store.MustEncodeSet("/lock/y", "b", store.Missing))
-	fp.Propose(store.MustEncodeSet("/lock/z", "a", store.Missing))
+	fp.Propose(store.MustEncodeSet("/lock/x", "a", store.Missing), nil)
+	fp.Propose(store.MustEncodeSet("/lock/y", "b", store.Missing), nil)
+	fp.Propose(store.MustEncodeSet("/lock/z", "a", store.Missing), nil)
 
 	// watch the locks to be deleted
-	ch := fp.Watch("/lock/*")
+	ch := fp.MustWatch("/lock/*")
 
 	// end the session
-	fp.Propose(store.MustEncodeDel("/session/a", store.Clobber))
+	fp.Propose(store.MustEncodeDel("/session/a", store.Clobber), nil)
 
 	// now that the session has ended, check all locks it owned are released
 	assert.Equal(t, "/lock/x", (<-ch).Path)
@@ -0,0 +1,56 @@
+package doozer
+
+import (
+	"doozer/store"
+	"doozer/util"
+	"path"
+)
+
+// Log levels are set at run time by writing to files in this directory.
+// Each file is named for a subsystem, such as "store" or "server", and holds
+// a level name, such as "debug". The file "default" sets the level of every
+// subsystem that has no file of its own. Deleting a file puts its subsystem
+// back to the default.
+const logConfDir = "/doozer/config/log"
+
+const logConfDefault = "default"
+
+func watchLogConf(st *store.Store) {
+	logger := util.NewLogger("main")
+	ch := make(chan store.Event)
+	err := st.GetDirAndWatch(logConfDir, ch)
+	if err != nil {
+		logger.Println(err)
+		return
+	}
+
+	for ev := range ch {
+		applyLogConf(logger, ev)
+	}
+}
+
+func applyLogConf(logger *util.Logger, ev store.Event) {
+	_, sub := path.Split(ev.Path)
+	switch {
+	case ev.IsSet():
+		l, err := util.ParseLevel(ev.Body)
+		if err != nil {
+			logger.Warn("bad log level", "path", ev.Path, "err", err)
+			return
+		}
+
+		if sub == logConfDefault {
+			util.SetDefaultLevel(l)
+		} else {
+			util.SetLevel(sub, l)
+		}
+		logger.Info("log level", "subsystem", sub, "level", l)
+	case ev.IsDel():
+		if sub == logConfDefault {
+			util.SetDefaultLevel(util.Info)
+		} else {
+			util.ResetLevel(sub)
+		}
+		logger.Info("log level reset", "subsystem", sub)
+	}
+}
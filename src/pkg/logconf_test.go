@@ -0,0 +1,30 @@
+package doozer
+
+import (
+	"doozer/store"
+	"doozer/util"
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestLogConf(t *testing.T) {
+	st := store.New()
+	defer close(st.Ops)
+	defer util.ResetLevel("x")
+	defer util.SetDefaultLevel(util.Info)
+
+	go watchLogConf(st)
+
+	st.Ops <- store.Op{1, store.MustEncodeSet(logConfDir+"/x", "debug", store.Clobber)}
+	st.Ops <- store.Op{2, store.MustEncodeSet(logConfDir+"/default", "warn", store.Clobber)}
+	st.Ops <- store.Op{3, store.MustEncodeSet(logConfDir+"/y", "bogus", store.Clobber)}
+	for util.LevelOf("x") != util.Debug || util.LevelOf("z") != util.Warn {
+		st.Sync(3)
+	}
+	assert.Equal(t, util.Warn, util.LevelOf("y"))
+
+	st.Ops <- store.Op{4, store.MustEncodeDel(logConfDir+"/x", store.Clobber)}
+	for util.LevelOf("x") != util.Warn {
+		st.Sync(4)
+	}
+}
@@ -9,7 +9,7 @@ import (
 
 func Clean(st *store.Store, p paxos.Proposer) {
 	logger := util.NewLogger("member")
-	for ev := range st.Watch("/session/*") {
+	for ev := range st.MustWatch("/session/*") {
 		if !ev.IsDel() {
 			continue
 		}
@@ -32,7 +32,7 @@ func clearSlot(p paxos.Proposer, g store.Getter, name string) {
 
 	for ev := range ch {
 		if ev.Body == name {
-			paxos.Set(p, ev.Path, "", ev.Cas)
+			paxos.Set(p, ev.Path, "", ev.Cas, nil)
 		}
 	}
 }
@@ -41,7 +41,7 @@ func removeMember(p paxos.Proposer, g store.Getter, name string) {
 	k := "/doozer/members/" + name
 	_, cas := g.Get(k)
 	if cas != store.Missing {
-		paxos.Del(p, k, cas)
+		paxos.Del(p, k, cas, nil)
 	}
 }
 
@@ -52,6 +52,6 @@ func removeInfo(p paxos.Proposer, g store.Getter, name string) {
 	}
 
 	for ev := range ch {
-		paxos.Del(p, ev.Path, ev.Cas)
+		paxos.Del(p, ev.Path, ev.Cas, nil)
 	}
 }
@@ -14,7 +14,7 @@ func TestMemberSimple(t *testing.T) {
 	go Clean(fp.Store, fp)
 
 	// start our session
-	fp.Propose(store.MustEncodeSet("/session/a", "foo", store.Missing))
+	fp.Propose(store.MustEncodeSet("/session/a", "foo", store.Missing), nil)
 
 	keys := map[string]string{
 		"/doozer/slot/0":    "a",
@@ -25,14 +25,14 @@ func TestMemberSimple(t *testing.T) {
 
 	// join the cluster
 	for k, p := range keys {
-		fp.Propose(store.MustEncodeSet(k, p, store.Missing))
+		fp.Propose(store.MustEncodeSet(k, p, store.Missing), nil)
 	}
 
 	// watch the keys to be deleted
-	ch := fp.Watch("/doozer/**")
+	ch := fp.MustWatch("/doozer/**")
 
 	// end the session
-	fp.Propose(store.MustEncodeDel("/session/a", store.Clobber))
+	fp.Propose(store.MustEncodeDel("/session/a", store.Clobber), nil)
 
 	// now that the session has ended, check its membership is cleaned up
 	for i := 0; i < len(keys); i++ {
@@ -0,0 +1,129 @@
+package mon
+
+import (
+	"doozer/exec"
+	"doozer/store"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Defaults for stopping a service. The timeout is in seconds.
+const (
+	defStopSignal  = syscall.SIGTERM
+	defStopTimeout = 10
+)
+
+var signals = map[string]int{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+var rlimits = map[string]int{
+	"service/limit-nofile": syscall.RLIMIT_NOFILE,
+	"service/limit-core":   syscall.RLIMIT_CORE,
+	"service/limit-as":     syscall.RLIMIT_AS,
+}
+
+const rlimInfinity = ^uint64(0)
+
+// Parses a signal given by name, with or without "SIG", or by number.
+func parseSignal(s string) (int, os.Error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return n, nil
+	}
+
+	name := strings.ToUpper(s)
+	if strings.HasPrefix(name, "SIG") {
+		name = name[3:]
+	}
+	if sig, ok := signals[name]; ok {
+		return sig, nil
+	}
+	return 0, os.NewError("unknown signal: " + s)
+}
+
+// Parses a resource limit, which is "soft:hard", or one value for both.
+// Either value may be "infinity".
+func parseRlimit(s string) (cur, max uint64, err os.Error) {
+	parse := func(v string) (uint64, os.Error) {
+		if v == "infinity" {
+			return rlimInfinity, nil
+		}
+		return strconv.Atoui64(v)
+	}
+
+	parts := strings.Split(s, ":", 2)
+	cur, err = parse(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	max = cur
+	if len(parts) == 2 {
+		max, err = parse(parts[1])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if cur > max {
+		return 0, 0, os.NewError("soft limit above hard limit: " + s)
+	}
+	return cur, max, nil
+}
+
+// Reads the parameters of service `id` that say how to run its process:
+//
+//   service/env/<name>       the value of environment variable <name>
+//   service/env-from/<name>  a path in the store, whose value is that of
+//                            environment variable <name>
+//   service/limit-nofile     limits on open files, core file size and
+//   service/limit-core       address space, as "soft:hard" or one value
+//   service/limit-as         for both
+//   service/umask            the umask, in octal
+func lookupContext(mon *monitor, id string) (cx exec.Context, err os.Error) {
+	dir := defDir + id + "/service/env"
+	for _, name := range store.GetDir(mon.st, dir) {
+		cx.Env = append(cx.Env, name+"="+store.GetString(mon.st, dir+"/"+name))
+	}
+
+	dir = defDir + id + "/service/env-from"
+	for _, name := range store.GetDir(mon.st, dir) {
+		p := store.GetString(mon.st, dir+"/"+name)
+		if v, cas := mon.st.Get(p); cas != store.Missing && cas != store.Dir {
+			cx.Env = append(cx.Env, name+"="+v[0])
+		} else {
+			return cx, os.NewError("env-from " + name + ": no value at " + p)
+		}
+	}
+
+	for param, resource := range rlimits {
+		s := mon.lookupParam(id, param)
+		if s == "" {
+			continue
+		}
+
+		cur, max, err := parseRlimit(s)
+		if err != nil {
+			return cx, os.NewError(param + ": " + err.String())
+		}
+		cx.Rlimits = append(cx.Rlimits, exec.Rlimit{resource, cur, max})
+	}
+
+	if s := mon.lookupParam(id, "service/umask"); s != "" {
+		umask, err := strconv.Btoui64(s, 8)
+		if err != nil || umask > 0777 {
+			return cx, os.NewError("service/umask: bad umask: " + s)
+		}
+		cx.Umask, cx.SetUmask = int(umask), true
+	}
+
+	return cx, nil
+}
@@ -0,0 +1,82 @@
+package mon
+
+import (
+	"doozer/exec"
+	"github.com/bmizerany/assert"
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, s := range []string{"TERM", "SIGTERM", "sigterm", "15"} {
+		sig, err := parseSignal(s)
+		assert.Equal(t, nil, err, s)
+		assert.Equal(t, syscall.SIGTERM, sig, s)
+	}
+
+	_, err := parseSignal("BOGUS")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestParseRlimit(t *testing.T) {
+	cur, max, err := parseRlimit("1024")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1024), cur)
+	assert.Equal(t, uint64(1024), max)
+
+	cur, max, err = parseRlimit("0:infinity")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0), cur)
+	assert.Equal(t, rlimInfinity, max)
+
+	_, _, err = parseRlimit("2:1")
+	assert.NotEqual(t, nil, err)
+
+	_, _, err = parseRlimit("x")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestLookupContext(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/env/FOO", "bar")
+	dt.set(defDir+"a.service/service/env-from/DB", "/config/db")
+	dt.set("/config/db", "db.example.com")
+	dt.set(defDir+"a.service/service/limit-nofile", "4096")
+	dt.set(defDir+"a.service/service/umask", "022")
+
+	cx, err := lookupContext(dt.mon, "a.service")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"FOO=bar", "DB=db.example.com"}, cx.Env)
+	assert.Equal(t, []exec.Rlimit{{syscall.RLIMIT_NOFILE, 4096, 4096}}, cx.Rlimits)
+	assert.Equal(t, true, cx.SetUmask)
+	assert.Equal(t, 022, cx.Umask)
+}
+
+func TestLookupContextUmaskZero(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/umask", "0")
+
+	cx, err := lookupContext(dt.mon, "a.service")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, cx.SetUmask)
+	assert.Equal(t, 0, cx.Umask)
+}
+
+func TestLookupContextBad(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/env-from/DB", "/missing")
+	_, err := lookupContext(dt.mon, "a.service")
+	assert.NotEqual(t, nil, err)
+
+	dt.set(defDir+"b.service/service/umask", "999")
+	_, err = lookupContext(dt.mon, "b.service")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestLookupContextNone(t *testing.T) {
+	dt := newDepsTest()
+	cx, err := lookupContext(dt.mon, "a.service")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, cx.SetUmask)
+	assert.Equal(t, 0, len(cx.Env))
+}
@@ -0,0 +1,258 @@
+package mon
+
+import (
+	"doozer/store"
+	"strings"
+)
+
+// Dependencies between units are given, after systemd, by these parameters
+// of a unit, each a space-separated list of unit ids:
+//
+//   unit/requires  units to start along with this one; if one of them fails
+//                  or is stopped, this one is stopped too
+//   unit/wants     units to start along with this one, whether or not they
+//                  work out
+//   unit/after     units that must be up before this one starts
+//
+// Only "after" orders starts. A unit waits for those of its "after" units
+// that this monitor is also starting to report "up" (or "failed") in
+// /mon/status. Since status is kept in the store, it does not matter which
+// node runs them. A status written before this monitor started the unit may
+// be left over from an earlier run, so it counts only if another node holds
+// the unit's lock, and so is running it now. A unit whose dependencies form
+// a cycle is not started.
+//
+// The state of a unit's dependencies is reported in /mon/status/<id>/deps.
+type deps struct {
+	requires, wants, after []string
+}
+
+func (mon *monitor) lookupDeps(id string) deps {
+	return deps{
+		requires: strings.Fields(mon.lookupParam(id, "unit/requires")),
+		wants:    strings.Fields(mon.lookupParam(id, "unit/wants")),
+		after:    strings.Fields(mon.lookupParam(id, "unit/after")),
+	}
+}
+
+func contains(ids []string, id string) bool {
+	for _, s := range ids {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a cycle among the dependencies reachable from `id`, starting and
+// ending with the same unit, or nil if there is none.
+func (mon *monitor) findCycle(id string) []string {
+	const (
+		visiting = iota + 1
+		done
+	)
+
+	state := make(map[string]int)
+	var stack []string
+
+	var visit func(u string) []string
+	visit = func(u string) []string {
+		switch state[u] {
+		case visiting:
+			for i, v := range stack {
+				if v == u {
+					return append(append([]string{}, stack[i:]...), u)
+				}
+			}
+		case done:
+			return nil
+		}
+
+		state[u] = visiting
+		stack = append(stack, u)
+		d := mon.lookupDeps(u)
+		for _, ids := range [][]string{d.requires, d.wants, d.after} {
+			for _, v := range ids {
+				if c := visit(v); c != nil {
+					return c
+				}
+			}
+		}
+		stack = stack[0 : len(stack)-1]
+		state[u] = done
+		return nil
+	}
+
+	return visit(id)
+}
+
+// Starts `id` and, first, what it depends on.
+func (mon *monitor) startUnit(id string) {
+	if c := mon.findCycle(id); c != nil {
+		mon.refuse(id, "dependency cycle: "+strings.Join(c, " -> "))
+		return
+	}
+
+	// If `id` was wanted already, its dependencies are pulled in, but it may
+	// have failed or stopped since; start it again all the same.
+	if mon.pullIn(id) {
+		mon.pending[id] = true
+	}
+	mon.runPending()
+}
+
+// Marks `id` and its dependencies as wanted and waiting to start. Returns
+// false iff `id` cannot start because a unit it requires cannot.
+func (mon *monitor) pullIn(id string) bool {
+	if mon.wanted[id] {
+		return true
+	}
+	mon.wanted[id] = true
+
+	d := mon.lookupDeps(id)
+	for _, dep := range d.requires {
+		if !mon.pullDep(id, dep) {
+			mon.wanted[id] = false, false
+			mon.refuse(id, "required unit "+dep+" cannot start")
+			return false
+		}
+	}
+	for _, dep := range d.wants {
+		mon.pullDep(id, dep)
+	}
+
+	mon.pending[id] = true
+	return true
+}
+
+// Holds a reference to `dep` on behalf of `id`, for as long as `id` exists.
+func (mon *monitor) pullDep(id, dep string) bool {
+	if !contains(mon.pulled[id], dep) {
+		if mon.increfUnit(dep) == nil {
+			return false
+		}
+		mon.pulled[id] = append(mon.pulled[id], dep)
+	}
+	return mon.pullIn(dep)
+}
+
+// Returns the "after" units of `id` that it must still wait for.
+func (mon *monitor) waitingOn(id string) (ids []string) {
+	for _, dep := range mon.lookupDeps(id).after {
+		if !mon.wanted[dep] {
+			continue
+		}
+
+		if !mon.settled(dep) {
+			ids = append(ids, dep)
+		}
+	}
+	return ids
+}
+
+// Returns true iff `id` has come up or failed in this run. See deps.
+func (mon *monitor) settled(id string) bool {
+	seqn, ok := mon.started[id]
+	if !ok {
+		return false
+	}
+
+	p := statusDir + id + "/status"
+	switch store.GetString(mon.st, p) {
+	case "up", "failed":
+	default:
+		return false
+	}
+
+	if s, _ := mon.st.Stat(p); s.Modified > seqn {
+		return true
+	}
+
+	holder := store.GetString(mon.st, lockDir+id)
+	return holder != "" && holder != mon.self
+}
+
+// Starts each pending unit that has nothing left to wait for.
+func (mon *monitor) runPending() {
+	for id := range mon.pending {
+		if ids := mon.waitingOn(id); len(ids) > 0 {
+			mon.setDepsStatus(id, "waiting on "+strings.Join(ids, " "))
+			continue
+		}
+
+		mon.pending[id] = false, false
+		mon.setDepsStatus(id, "ok")
+		if _, ok := mon.started[id]; !ok {
+			mon.started[id] = <-mon.st.Seqns
+		}
+		if ut := mon.units[id]; ut != nil {
+			ut.start()
+		}
+	}
+}
+
+// Returns the wanted units that require `id`.
+func (mon *monitor) dependents(id string) (ids []string) {
+	for u := range mon.wanted {
+		if contains(mon.lookupDeps(u).requires, id) {
+			ids = append(ids, u)
+		}
+	}
+	return ids
+}
+
+// Stops `id`, after first stopping the units that require it. `why` is
+// reported as the state of its dependencies.
+func (mon *monitor) stopUnit(id, why string) {
+	mon.wanted[id] = false, false
+	mon.pending[id] = false, false
+	mon.started[id] = 0, false
+
+	for _, d := range mon.dependents(id) {
+		mon.logger.Info("stopping dependent", "unit", d, "requires", id)
+		mon.stopUnit(d, "stopped: required unit "+id+" stopped")
+	}
+
+	mon.setDepsStatus(id, why)
+	if ut := mon.units[id]; ut != nil {
+		ut.stop()
+	}
+}
+
+// Called when a unit reports a new status in the store.
+func (mon *monitor) statusChanged(id, status string) {
+	if status == "failed" {
+		for _, d := range mon.dependents(id) {
+			mon.logger.Info("stopping dependent", "unit", d, "failed", id)
+			mon.stopUnit(d, "failed: required unit "+id+" failed")
+		}
+	}
+	mon.runPending()
+}
+
+func (mon *monitor) refuse(id, reason string) {
+	mon.logger.Warn("not starting", "unit", id, "reason", reason)
+	mon.pending[id] = false, false
+	mon.setDepsStatus(id, reason)
+	go mon.setStatus(id, "status", "failed")
+	go mon.setStatus(id, "reason", reason)
+}
+
+func (mon *monitor) setDepsStatus(id, s string) {
+	if mon.depsStatus[id] == s {
+		return
+	}
+	mon.depsStatus[id] = s
+	go mon.setStatus(id, "deps", s)
+}
+
+// Forgets the dependencies of `id`, which is going away.
+func (mon *monitor) dropDeps(id string) {
+	pulled := mon.pulled[id]
+	mon.pulled[id] = nil, false
+	mon.depsStatus[id] = "", false
+	mon.started[id] = 0, false
+	for _, dep := range pulled {
+		mon.decrefUnit(dep)
+	}
+}
@@ -0,0 +1,178 @@
+package mon
+
+import (
+	"doozer/store"
+	"github.com/bmizerany/assert"
+	"os"
+	"testing"
+)
+
+type nopSetDeler struct{}
+
+func (nopSetDeler) Set(p, body, oldCas string) (string, os.Error) {
+	return "", nil
+}
+
+func (nopSetDeler) Del(p, cas string) os.Error {
+	return nil
+}
+
+// Records starts and stops in a log shared by all fake units.
+type fakeUnit struct {
+	id  string
+	log *[]string
+}
+
+func (fu *fakeUnit) dispatchLockEvent(ev store.Event) {}
+
+func (fu *fakeUnit) start() {
+	*fu.log = append(*fu.log, "start "+fu.id)
+}
+
+func (fu *fakeUnit) stop() {
+	*fu.log = append(*fu.log, "stop "+fu.id)
+}
+
+type depsTest struct {
+	mon  *monitor
+	seqn uint64
+	log  []string
+}
+
+func newDepsTest(ids ...string) *depsTest {
+	dt := new(depsTest)
+	dt.mon = newMonitor("self", store.New(), nopSetDeler{})
+	for _, id := range ids {
+		dt.mon.units[id] = &fakeUnit{id, &dt.log}
+	}
+	return dt
+}
+
+func (dt *depsTest) set(path, body string) {
+	dt.seqn++
+	dt.mon.st.Ops <- store.Op{dt.seqn, store.MustEncodeSet(path, body, store.Clobber)}
+	dt.mon.st.Sync(dt.seqn)
+}
+
+func (dt *depsTest) setStatus(id, status string) {
+	dt.set(statusDir+id+"/status", status)
+	dt.mon.statusChanged(id, status)
+}
+
+func TestFindCycle(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/unit/requires", "b.service")
+	dt.set(defDir+"b.service/unit/wants", "c.service")
+	assert.Equal(t, []string(nil), dt.mon.findCycle("a.service"))
+
+	dt.set(defDir+"c.service/unit/after", "b.service")
+	exp := []string{"b.service", "c.service", "b.service"}
+	assert.Equal(t, exp, dt.mon.findCycle("a.service"))
+}
+
+func TestStartRefusesCycle(t *testing.T) {
+	dt := newDepsTest("a.service", "b.service")
+	dt.set(defDir+"a.service/unit/requires", "b.service")
+	dt.set(defDir+"b.service/unit/requires", "a.service")
+
+	dt.mon.startUnit("a.service")
+	assert.Equal(t, []string(nil), dt.log)
+	assert.Equal(t, "dependency cycle: a.service -> b.service -> a.service", dt.mon.depsStatus["a.service"])
+}
+
+func TestStartOrdersAfter(t *testing.T) {
+	dt := newDepsTest("a.service", "b.socket")
+	dt.set(defDir+"a.service/unit/requires", "b.socket")
+	dt.set(defDir+"a.service/unit/after", "b.socket")
+
+	dt.mon.startUnit("a.service")
+	assert.Equal(t, []string{"start b.socket"}, dt.log)
+	assert.Equal(t, "waiting on b.socket", dt.mon.depsStatus["a.service"])
+	assert.Equal(t, []string{"b.socket"}, dt.mon.pulled["a.service"])
+
+	dt.setStatus("b.socket", "up")
+	assert.Equal(t, []string{"start b.socket", "start a.service"}, dt.log)
+	assert.Equal(t, "ok", dt.mon.depsStatus["a.service"])
+}
+
+func TestAfterIgnoresOldStatus(t *testing.T) {
+	dt := newDepsTest("a.service", "b.socket")
+	dt.set(defDir+"a.service/unit/after", "b.socket")
+	dt.set(defDir+"a.service/unit/wants", "b.socket")
+	dt.set(statusDir+"b.socket/status", "up") // from an earlier run
+
+	dt.mon.startUnit("a.service")
+	assert.Equal(t, []string{"start b.socket"}, dt.log)
+	assert.Equal(t, "waiting on b.socket", dt.mon.depsStatus["a.service"])
+
+	dt.setStatus("b.socket", "up")
+	assert.Equal(t, []string{"start b.socket", "start a.service"}, dt.log)
+}
+
+func TestAfterRunningElsewhere(t *testing.T) {
+	dt := newDepsTest("a.service", "b.socket")
+	dt.set(defDir+"a.service/unit/after", "b.socket")
+	dt.set(defDir+"a.service/unit/wants", "b.socket")
+	dt.set(statusDir+"b.socket/status", "up")
+	dt.set(lockDir+"b.socket", "other")
+
+	dt.mon.startUnit("a.service")
+	assert.Equal(t, []string{"start b.socket", "start a.service"}, dt.log)
+}
+
+func TestStartAgain(t *testing.T) {
+	dt := newDepsTest("a.service", "b.service")
+	dt.set(defDir+"a.service/unit/requires", "b.service")
+
+	dt.mon.startUnit("a.service")
+	dt.log = nil
+
+	// Still wanted, but asked to start again, as after it has failed.
+	dt.mon.startUnit("a.service")
+	assert.Equal(t, []string{"start a.service"}, dt.log)
+	assert.Equal(t, []string{"b.service"}, dt.mon.pulled["a.service"])
+}
+
+func TestWantsDoesNotOrder(t *testing.T) {
+	dt := newDepsTest("a.service", "b.service")
+	dt.set(defDir+"a.service/unit/wants", "b.service")
+
+	dt.mon.startUnit("a.service")
+	assert.Equal(t, 2, len(dt.log))
+	assert.T(t, dt.mon.wanted["b.service"])
+}
+
+func TestFailureStopsDependents(t *testing.T) {
+	dt := newDepsTest("a.service", "b.service", "c.service")
+	dt.set(defDir+"a.service/unit/requires", "b.service")
+	dt.set(defDir+"b.service/unit/requires", "c.service")
+
+	dt.mon.startUnit("a.service")
+	dt.log = nil
+
+	dt.setStatus("c.service", "failed")
+	assert.Equal(t, []string{"stop a.service", "stop b.service"}, dt.log)
+	assert.Equal(t, "failed: required unit c.service failed", dt.mon.depsStatus["b.service"])
+	assert.Equal(t, "stopped: required unit b.service stopped", dt.mon.depsStatus["a.service"])
+}
+
+func TestStopStopsDependentsFirst(t *testing.T) {
+	dt := newDepsTest("a.service", "b.service")
+	dt.set(defDir+"a.service/unit/requires", "b.service")
+
+	dt.mon.startUnit("a.service")
+	dt.log = nil
+
+	dt.mon.stopUnit("b.service", "stopped")
+	assert.Equal(t, []string{"stop a.service", "stop b.service"}, dt.log)
+	assert.Equal(t, false, dt.mon.wanted["a.service"])
+}
+
+func TestMissingRequirement(t *testing.T) {
+	dt := newDepsTest("a.service")
+	dt.set(defDir+"a.service/unit/requires", "b.bogus")
+
+	dt.mon.startUnit("a.service")
+	assert.Equal(t, []string(nil), dt.log)
+	assert.Equal(t, "required unit b.bogus cannot start", dt.mon.depsStatus["a.service"])
+}
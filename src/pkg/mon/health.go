@@ -0,0 +1,193 @@
+package mon
+
+import (
+	"doozer/exec"
+	"http"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Defaults for the parameters of a health check. Times are in seconds.
+const (
+	defCheckInterval = 10
+	defCheckTimeout  = 5
+	defCheckFailures = 3
+)
+
+var errCheckTimeout = os.NewError("health check timed out")
+
+type healther interface {
+	health(pid int, err os.Error)
+}
+
+type health struct {
+	h   healther
+	pid int
+	err os.Error
+}
+
+// A health check of a running service, given by exactly one of these
+// parameters:
+//
+//   service/check-exec  a command, which passes if it exits with status 0
+//   service/check-tcp   an address, which passes if it accepts a connection
+//   service/check-http  a URL, which passes if a GET of it gets a 2xx or 3xx
+//
+// The check is made every "service/check-interval" seconds, and fails if it
+// takes more than "service/check-timeout" seconds. After
+// "service/check-failures" failures in a row, the service is killed.
+type healthCheck struct {
+	kind, arg string
+	interval  int64 // ns
+	timeout   int64 // ns
+	failures  int
+	cx        exec.Context
+}
+
+// Returns nil if service `id` has no health check.
+func lookupHealthCheck(mon *monitor, id string, cx exec.Context) (*healthCheck, os.Error) {
+	hc := &healthCheck{cx: cx}
+	for _, kind := range []string{"exec", "tcp", "http"} {
+		arg := mon.lookupParam(id, "service/check-"+kind)
+		if arg == "" {
+			continue
+		}
+		if hc.kind != "" {
+			return nil, os.NewError("health check: more than one of exec, tcp and http")
+		}
+		hc.kind, hc.arg = kind, arg
+	}
+	if hc.kind == "" {
+		return nil, nil
+	}
+
+	var err os.Error
+	hc.interval, err = lookupSeconds(mon, id, "service/check-interval", defCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+	hc.timeout, err = lookupSeconds(mon, id, "service/check-timeout", defCheckTimeout)
+	if err != nil {
+		return nil, err
+	}
+	hc.failures, err = lookupInt(mon, id, "service/check-failures", defCheckFailures)
+	if err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+// Reads a parameter giving a number of seconds, which may have a fraction,
+// and returns it in ns. The number must be positive.
+func lookupSeconds(mon *monitor, id, param string, def float64) (int64, os.Error) {
+	s := mon.lookupParam(id, param)
+	if s == "" {
+		return int64(def * 1e9), nil
+	}
+
+	f, err := strconv.Atof64(s)
+	if err != nil || f <= 0 {
+		return 0, os.NewError(param + ": bad number of seconds: " + s)
+	}
+	return int64(f * 1e9), nil
+}
+
+// Reads a parameter giving a positive integer.
+func lookupInt(mon *monitor, id, param string, def int) (int, os.Error) {
+	s := mon.lookupParam(id, param)
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, os.NewError(param + ": bad number: " + s)
+	}
+	return n, nil
+}
+
+// Checks process `pid` every interval until `done` is closed, and sends
+// the results to the monitor.
+func (mon *monitor) checkHealth(hc *healthCheck, pid int, h healther, done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(hc.interval):
+		}
+
+		err := hc.run()
+
+		select {
+		case <-done:
+			return
+		case mon.healthCh <- health{h, pid, err}:
+		}
+	}
+}
+
+// Runs the check once. Returns nil iff it passes.
+func (hc *healthCheck) run() os.Error {
+	res := make(chan os.Error, 1)
+	var pid int
+
+	switch hc.kind {
+	case "exec":
+		var err os.Error
+		pid, err = hc.cx.ForkExec(hc.arg, nil)
+		if err != nil {
+			return err
+		}
+		go func() { res <- waitCheck(pid) }()
+	case "tcp":
+		go func() { res <- dialCheck(hc.arg) }()
+	case "http":
+		go func() { res <- getCheck(hc.arg) }()
+	}
+
+	select {
+	case err := <-res:
+		return err
+	case <-time.After(hc.timeout):
+	}
+
+	if pid != 0 {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+	return errCheckTimeout
+}
+
+func waitCheck(pid int) os.Error {
+	w, err := os.Wait(pid, 0)
+	if err != nil {
+		return err
+	}
+	if !exitedCleanly(w) {
+		return os.NewError("health check: " + w.String())
+	}
+	return nil
+}
+
+func dialCheck(addr string) os.Error {
+	c, err := net.Dial("tcp", "", addr)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+func getCheck(url string) os.Error {
+	r, _, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if r.StatusCode < 200 || r.StatusCode >= 400 {
+		return os.NewError("health check: " + r.Status)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package mon
+
+import (
+	"doozer/exec"
+	"github.com/bmizerany/assert"
+	"net"
+	"testing"
+)
+
+func TestLookupHealthCheckNone(t *testing.T) {
+	dt := newDepsTest()
+	hc, err := lookupHealthCheck(dt.mon, "a.service", exec.Context{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, (*healthCheck)(nil), hc)
+}
+
+func TestLookupHealthCheck(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/check-tcp", "127.0.0.1:1")
+	dt.set(defDir+"a.service/service/check-interval", "0.5")
+
+	hc, err := lookupHealthCheck(dt.mon, "a.service", exec.Context{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "tcp", hc.kind)
+	assert.Equal(t, "127.0.0.1:1", hc.arg)
+	assert.Equal(t, int64(5e8), hc.interval)
+	assert.Equal(t, int64(defCheckTimeout*1e9), hc.timeout)
+	assert.Equal(t, defCheckFailures, hc.failures)
+}
+
+func TestLookupHealthCheckBad(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/check-tcp", "127.0.0.1:1")
+	dt.set(defDir+"a.service/service/check-failures", "0")
+	_, err := lookupHealthCheck(dt.mon, "a.service", exec.Context{})
+	assert.NotEqual(t, nil, err)
+
+	dt.set(defDir+"b.service/service/check-tcp", "127.0.0.1:1")
+	dt.set(defDir+"b.service/service/check-http", "http://127.0.0.1:1/")
+	_, err = lookupHealthCheck(dt.mon, "b.service", exec.Context{})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestDialCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	addr := l.Addr().String()
+
+	hc := &healthCheck{kind: "tcp", arg: addr, timeout: 1e9}
+	assert.Equal(t, nil, hc.run())
+
+	l.Close()
+	assert.NotEqual(t, nil, hc.run())
+}
+
+func TestBackOff(t *testing.T) {
+	sv := &service{delayMin: 1e8, delayMax: 5e8, window: 10e9}
+
+	var got []int64
+	for i := 0; i < 5; i++ {
+		sv.backOff(0)
+		got = append(got, sv.delay)
+	}
+	assert.Equal(t, []int64{1e8, 2e8, 4e8, 5e8, 5e8}, got)
+
+	// Having stayed up a while, it starts over.
+	sv.backOff(10e9)
+	assert.Equal(t, int64(1e8), sv.delay)
+}
+
+func TestCountStart(t *testing.T) {
+	sv := &service{burst: 2, window: 10e9}
+	assert.T(t, sv.countStart())
+	assert.T(t, sv.countStart())
+	assert.T(t, !sv.countStart())
+
+	// Starts outside the window don't count.
+	sv = &service{burst: 1, window: 1}
+	assert.T(t, sv.countStart())
+	sv.starts[0] -= 2
+	assert.T(t, sv.countStart())
+}
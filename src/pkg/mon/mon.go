@@ -3,9 +3,11 @@ package mon
 import (
 	"doozer/store"
 	"doozer/util"
-	"log"
+	"http"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -56,15 +58,24 @@ type SetDeler interface {
 }
 
 type monitor struct {
-	self    string
-	st      *store.Store
-	cl      SetDeler
-	clock   chan ticker
-	units   map[string]unit
-	refs    map[string]int
-	exitCh  chan exit
-	readyCh chan ready
-	logger  *log.Logger
+	self       string
+	st         *store.Store
+	cl         SetDeler
+	clock      chan ticker
+	units      map[string]unit
+	refs       map[string]int
+	wanted     map[string]bool
+	pending    map[string]bool
+	pulled     map[string][]string
+	started    map[string]uint64 // seqn at which each unit was started
+	depsStatus map[string]string
+	exitCh     chan exit
+	readyCh    chan ready
+	healthCh   chan health
+	lockCh     chan lockFail
+	logs       map[string]*serviceLogs
+	logsLk     sync.Mutex
+	logger     *util.Logger
 }
 
 func splitId(id string) (name, ext string) {
@@ -73,29 +84,57 @@ func splitId(id string) (name, ext string) {
 	return
 }
 
-func Monitor(self string, st *store.Store, cl SetDeler) os.Error {
-
-	mon := &monitor{
-		self:    self,
-		st:      st,
-		cl:      cl,
-		clock:   make(chan ticker),
-		units:   make(map[string]unit),
-		refs:    make(map[string]int),
-		exitCh:  make(chan exit),
-		readyCh: make(chan ready),
-		logger:  util.NewLogger("monitor"),
+func newMonitor(self string, st *store.Store, cl SetDeler) *monitor {
+	return &monitor{
+		self:       self,
+		st:         st,
+		cl:         cl,
+		clock:      make(chan ticker),
+		units:      make(map[string]unit),
+		refs:       make(map[string]int),
+		wanted:     make(map[string]bool),
+		pending:    make(map[string]bool),
+		pulled:     make(map[string][]string),
+		started:    make(map[string]uint64),
+		depsStatus: make(map[string]string),
+		exitCh:     make(chan exit),
+		readyCh:    make(chan ready),
+		healthCh:   make(chan health),
+		lockCh:     make(chan lockFail),
+		logs:       make(map[string]*serviceLogs),
+		logger:     util.NewLogger("mon"),
+	}
+}
+
+// Runs the units in ctlKey on this node. If `mux` is not nil, the output of
+// the services run here is served on it under logPath; to show it on the web
+// server, pass http.DefaultServeMux.
+func Monitor(self string, st *store.Store, cl SetDeler, mux *http.ServeMux) os.Error {
+	mon := newMonitor(self, st, cl)
+	if mux != nil {
+		mux.Handle(logPath, logHandler{mon})
 	}
 
 	mon.logger.Println("reading units")
 	evs := make(chan store.Event)
-	st.GetDirAndWatch(ctlKey, evs)
+	err := st.GetDirAndWatch(ctlKey, evs)
+	if err != nil {
+		return err
+	}
+
 	go func(c <-chan store.Event) {
 		for e := range c {
 			evs <- e
 		}
 		close(evs)
-	}(st.Watch(lockKey + "/*"))
+	}(st.MustWatch(lockKey + "/*"))
+	for _, glob := range []string{statusKey + "/*/status", statusKey + "/*/rollout", rolloutKey + "/*"} {
+		go func(c <-chan store.Event) {
+			for e := range c {
+				evs <- e
+			}
+		}(st.MustWatch(glob))
+	}
 
 	for {
 		select {
@@ -118,9 +157,15 @@ func Monitor(self string, st *store.Store, cl SetDeler) os.Error {
 
 				switch ev.Body {
 				case "start":
-					ut.start()
+					mon.startUnit(id)
 				case "stop":
-					ut.stop()
+					mon.stopUnit(id, "stopped")
+				case "restart":
+					if sv, ok := ut.(*service); ok && sv.pid != 0 {
+						sv.restartNow()
+					} else {
+						mon.startUnit(id)
+					}
 				case "auto", "":
 					fallthrough
 				default:
@@ -128,17 +173,45 @@ func Monitor(self string, st *store.Store, cl SetDeler) os.Error {
 				}
 
 			case lockDir:
-				ut := mon.units[id]
+				uid, i := splitInstance(id)
+				ut := mon.units[uid]
 				if ut == nil {
 					break
 				}
 
+				// Only services have more than one instance.
+				if _, ok := ut.(*service); i != 0 && !ok {
+					break
+				}
+
 				ut.dispatchLockEvent(ev)
+
+			case rolloutDir:
+				if sv, ok := mon.units[id].(*service); ok {
+					sv.checkRollout()
+				}
+
+			default:
+				if !strings.HasPrefix(prefix, statusDir) {
+					break
+				}
+
+				sid := prefix[len(statusDir) : len(prefix)-1]
+				switch id {
+				case "status":
+					mon.statusChanged(sid, ev.Body)
+				case "rollout":
+					mon.rolloutChanged(sid)
+				}
 			}
 		case e := <-mon.exitCh:
 			e.e.exited(e.w)
 		case r := <-mon.readyCh:
 			r.r.ready(r.f)
+		case h := <-mon.healthCh:
+			h.h.health(h.pid, h.err)
+		case l := <-mon.lockCh:
+			l.l.lockFailed(l.path, l.err)
 		}
 	}
 	panic("unreachable")
@@ -151,6 +224,10 @@ func (mon *monitor) newUnit(id string) unit {
 		return newService(id, name, mon)
 	case ".socket":
 		return newSocket(id, name, mon)
+	case ".timer":
+		return newTimer(id, name, mon)
+	case ".path":
+		return newPath(id, name, mon)
 	}
 	return nil
 }
@@ -167,8 +244,9 @@ func (mon *monitor) delStatus(id, param string) {
 	mon.cl.Del(statusKey+"/"+id+"/"+param, store.Clobber)
 }
 
-func (mon *monitor) tryLock(id string) {
-	mon.cl.Set(lockKey+"/"+id, mon.self, store.Missing)
+func (mon *monitor) tryLock(id string) os.Error {
+	_, err := mon.cl.Set(lockKey+"/"+id, mon.self, store.Missing)
+	return err
 }
 
 func (mon *monitor) release(id, cas string) {
@@ -246,9 +324,11 @@ func (mon *monitor) decrefUnit(id string) {
 	mon.refs[id]--
 	if mon.refs[id] < 1 {
 		mon.logger.Println(" -- destroying")
-		ut.stop()
+		mon.stopUnit(id, "stopped")
 		mon.units[id] = nil, false
 		mon.refs[id] = 0, false
+		mon.dropDeps(id)
+		mon.closeLogs(id)
 	}
 }
 
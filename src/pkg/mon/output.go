@@ -0,0 +1,279 @@
+package mon
+
+import (
+	"http"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Where the output of services run on this node is kept. Each stream of each
+// service goes to its own file, <id>.stdout or <id>.stderr. A file is rotated
+// when it would grow past "service/log-size" bytes; the last
+// "service/log-files" rotated files are kept, as <file>.1 (the newest),
+// <file>.2 and so on.
+var LogDir = "/var/log/doozer"
+
+const (
+	defLogSize  = 1 << 20
+	defLogFiles = 3
+
+	tailSize       = 64 * 1024 // bytes of each stream kept in memory
+	statusTailSize = 1024      // bytes of stderr reported when a service exits
+	drainTime      = 1e9       // ns to wait for output after a service exits
+)
+
+// The path under which Monitor serves the output of services, as
+// <logPath><id>, with "stream=stderr" for standard error.
+const logPath = "/mon/log/"
+
+type logFile struct {
+	path string
+	max  int64
+	keep int
+	f    *os.File
+	size int64
+}
+
+func openLogFile(path string, max int64, keep int) (*logFile, os.Error) {
+	f, err := os.Open(path, os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &logFile{path: path, max: max, keep: keep, f: f, size: fi.Size}, nil
+}
+
+func (lf *logFile) name(i int) string {
+	return lf.path + "." + strconv.Itoa(i)
+}
+
+func (lf *logFile) Write(p []byte) (int, os.Error) {
+	if lf.size > 0 && lf.size+int64(len(p)) > lf.max {
+		err := lf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+func (lf *logFile) rotate() os.Error {
+	lf.f.Close()
+
+	// Missing files are fine here; there may not have been many rotations.
+	os.Remove(lf.name(lf.keep))
+	for i := lf.keep - 1; i > 0; i-- {
+		os.Rename(lf.name(i), lf.name(i+1))
+	}
+	os.Rename(lf.path, lf.name(1))
+
+	f, err := os.Open(lf.path, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	lf.f, lf.size = f, 0
+	return nil
+}
+
+func (lf *logFile) Close() os.Error {
+	return lf.f.Close()
+}
+
+// One output stream of a service. Everything written is kept in a file, if
+// there is one, and the end of it is kept in memory. It is safe to use from
+// several goroutines.
+type output struct {
+	lk   sync.Mutex
+	file *logFile
+	tail []byte
+}
+
+func (o *output) Write(p []byte) (int, os.Error) {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	o.tail = append(o.tail, p...)
+	if len(o.tail) > 2*tailSize {
+		o.tail = append([]byte(nil), o.tail[len(o.tail)-tailSize:]...)
+	}
+
+	if o.file != nil {
+		_, err := o.file.Write(p)
+		if err != nil {
+			o.file.Close()
+			o.file = nil
+		}
+	}
+	return len(p), nil
+}
+
+// Returns a copy of the last `n` bytes written, or of all of them if there
+// are fewer.
+func (o *output) Tail(n int) []byte {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	if n > tailSize {
+		n = tailSize
+	}
+	if n > len(o.tail) {
+		n = len(o.tail)
+	}
+	return append([]byte(nil), o.tail[len(o.tail)-n:]...)
+}
+
+func (o *output) Close() {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+}
+
+// Returns the write end of a pipe whose output goes to `o`. The caller must
+// close it after giving it to a child process. The returned channel is
+// closed once everything written to the pipe is in `o`.
+func (o *output) pipe() (*os.File, chan bool, os.Error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan bool)
+	go func() {
+		io.Copy(o, r)
+		r.Close()
+		close(done)
+	}()
+	return w, done, nil
+}
+
+type serviceLogs struct {
+	stdout, stderr *output
+}
+
+// Returns the logs of service `id`, opening them if need be. If a file
+// can't be opened, output is kept only in memory, and the reason is
+// reported in /mon/status/<id>/log-error.
+func (mon *monitor) openLogs(id string) *serviceLogs {
+	mon.logsLk.Lock()
+	defer mon.logsLk.Unlock()
+
+	if sl, ok := mon.logs[id]; ok {
+		return sl
+	}
+
+	var keep int
+	size, err := lookupInt(mon, id, "service/log-size", defLogSize)
+	if err == nil {
+		keep, err = lookupInt(mon, id, "service/log-files", defLogFiles)
+	}
+
+	var sl *serviceLogs
+	if err != nil {
+		mon.logger.Warn("bad log parameters", "unit", id, "err", err)
+		go mon.setStatus(id, "log-error", err.String())
+		sl = &serviceLogs{new(output), new(output)}
+	} else {
+		sl = &serviceLogs{
+			stdout: mon.openOutput(id, "stdout", int64(size), keep),
+			stderr: mon.openOutput(id, "stderr", int64(size), keep),
+		}
+	}
+
+	mon.logs[id] = sl
+	return sl
+}
+
+func (mon *monitor) openOutput(id, stream string, size int64, keep int) *output {
+	p := path.Join(LogDir, id+"."+stream)
+	lf, err := openLogFile(p, size, keep)
+	if err != nil {
+		mon.logger.Warn("cannot open log file", "unit", id, "err", err)
+		go mon.setStatus(id, "log-error", err.String())
+		return new(output)
+	}
+
+	go mon.setStatus(id, stream+"-file", p)
+	return &output{file: lf}
+}
+
+func (mon *monitor) closeLogs(id string) {
+	mon.logsLk.Lock()
+	defer mon.logsLk.Unlock()
+
+	if sl, ok := mon.logs[id]; ok {
+		sl.stdout.Close()
+		sl.stderr.Close()
+		mon.logs[id] = nil, false
+	}
+}
+
+// Waits (for a while) until the output of an exited process is all in, then
+// reports the end of its stderr.
+func (sv *service) reportTail(stderr *output, done chan bool) {
+	select {
+	case <-done:
+	case <-time.After(drainTime):
+	}
+
+	sv.setStatus("stderr-tail", string(stderr.Tail(statusTailSize)))
+}
+
+// Serves the output of a service run on this node. The unit id follows
+// logPath in the URL. Query parameter "stream" may be "stdout" (the default)
+// or "stderr", and "n" is the number of bytes to show, at most tailSize.
+type logHandler struct {
+	mon *monitor
+}
+
+func (h logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[len(logPath):]
+
+	h.mon.logsLk.Lock()
+	sl, ok := h.mon.logs[id]
+	h.mon.logsLk.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var o *output
+	switch r.FormValue("stream") {
+	case "", "stdout":
+		o = sl.stdout
+	case "stderr":
+		o = sl.stderr
+	default:
+		http.Error(w, "bad stream", http.StatusBadRequest)
+		return
+	}
+
+	n := tailSize
+	if s := r.FormValue("n"); s != "" {
+		var err os.Error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "bad n", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.SetHeader("content-type", "text/plain; charset=utf-8")
+	w.Write(o.Tail(n))
+}
@@ -0,0 +1,93 @@
+package mon
+
+import (
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestOutputTail(t *testing.T) {
+	o := new(output)
+	o.Write([]byte("abc"))
+	o.Write([]byte("def"))
+	assert.Equal(t, []byte("ef"), o.Tail(2))
+	assert.Equal(t, []byte("abcdef"), o.Tail(100))
+}
+
+func TestOutputTailLimit(t *testing.T) {
+	o := new(output)
+	b := make([]byte, tailSize)
+	for i := 0; i < 3; i++ {
+		o.Write(b)
+	}
+	o.Write([]byte("x"))
+	assert.T(t, len(o.tail) <= 2*tailSize)
+	got := o.Tail(tailSize + 1)
+	assert.Equal(t, tailSize, len(got))
+	assert.Equal(t, byte('x'), got[len(got)-1])
+}
+
+func TestOutputPipe(t *testing.T) {
+	o := new(output)
+	w, done, err := o.pipe()
+	assert.Equal(t, nil, err)
+	w.Write([]byte("hello"))
+	w.Close()
+	<-done
+	assert.Equal(t, []byte("hello"), o.Tail(tailSize))
+}
+
+func mustTempDir() string {
+	dir := "/tmp/doozer-mon-test." + strconv.Itoa(os.Getpid())
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+func TestLogFileRotate(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	p := dir + "/a.service.stdout"
+	lf, err := openLogFile(p, 4, 2)
+	assert.Equal(t, nil, err)
+	for _, s := range []string{"aaa", "bbb", "ccc", "ddd"} {
+		_, err = lf.Write([]byte(s))
+		assert.Equal(t, nil, err)
+	}
+	lf.Close()
+
+	for file, exp := range map[string]string{p: "ddd", p + ".1": "ccc", p + ".2": "bbb"} {
+		b, err := ioutil.ReadFile(file)
+		assert.Equal(t, nil, err, file)
+		assert.Equal(t, exp, string(b), file)
+	}
+
+	_, err = os.Stat(p + ".3")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestLogFileAppends(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	p := dir + "/a.service.stderr"
+	lf, err := openLogFile(p, 100, 1)
+	assert.Equal(t, nil, err)
+	lf.Write([]byte("a"))
+	lf.Close()
+
+	lf, err = openLogFile(p, 100, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), lf.size)
+	lf.Write([]byte("b"))
+	lf.Close()
+
+	b, err := ioutil.ReadFile(p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ab", string(b))
+}
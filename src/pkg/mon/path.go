@@ -0,0 +1,169 @@
+package mon
+
+import (
+	"doozer/store"
+	"doozer/util"
+	"strconv"
+)
+
+// A path unit activates a service whenever something in the store matching
+// the glob "path/glob" changes. Like a timer, it is held by one node at a
+// time through /lock, so each change activates the service only once in
+// the whole cluster. The service defaults to the one with the unit's name,
+// and can be set with "path/unit".
+type pathUnit struct {
+	id, name  string
+	sv        *service
+	logger    *util.Logger
+	mon       *monitor
+	wantUp    bool
+	lockCas   string
+	lockTaken bool
+	evs       <-chan store.Event
+	changed   chan store.Event // latest change not yet acted on
+}
+
+func newPath(id, name string, mon *monitor) *pathUnit {
+	target := mon.lookupParam(id, "path/unit")
+	if target == "" {
+		target = name + ".service"
+	}
+
+	sv := mon.increfService(target)
+	if sv == nil {
+		return nil
+	}
+
+	pu := &pathUnit{
+		id:     id,
+		name:   name,
+		sv:     sv,
+		mon:    mon,
+		logger: util.NewLogger("mon").With("unit", id),
+	}
+	pu.logger.Println("new")
+	return pu
+}
+
+func (pu *pathUnit) lookupParam(param string) string {
+	return pu.mon.lookupParam(pu.id, param)
+}
+
+func (pu *pathUnit) setStatus(param, val string) {
+	pu.mon.setStatus(pu.id, param, val)
+}
+
+func (pu *pathUnit) delStatus(param string) {
+	pu.mon.delStatus(pu.id, param)
+}
+
+func (pu *pathUnit) open() {
+	if pu.evs != nil {
+		return
+	}
+
+	pu.logger.Println("open")
+
+	glob := pu.lookupParam("path/glob")
+	evs, err := pu.mon.st.WatchFilter(store.Filter{Globs: []string{glob}})
+	if err != nil {
+		pu.wantUp = false // fatal error -- don't retry
+		pu.logger.Println(err)
+		go pu.setStatus("status", "failed")
+		go pu.setStatus("reason", err.String())
+		go pu.delStatus("glob")
+		return
+	}
+
+	pu.evs, pu.changed = evs, make(chan store.Event, 1)
+	go pu.setStatus("status", "up")
+	go pu.delStatus("reason")
+	go pu.setStatus("glob", glob)
+	go pu.forward(evs, pu.changed)
+}
+
+// Passes changes from the store to the monitor's goroutine. Changes that
+// come faster than the monitor takes them are merged into one; only the
+// latest is kept.
+func (pu *pathUnit) forward(evs <-chan store.Event, changed chan store.Event) {
+	for ev := range evs {
+		select {
+		case <-changed:
+		default:
+		}
+		changed <- ev
+		pu.mon.clock <- pu
+	}
+}
+
+func (pu *pathUnit) close() {
+	if pu.evs == nil {
+		return
+	}
+
+	close(pu.evs)
+	pu.evs, pu.changed = nil, nil
+	pu.logger.Println("closed, updating status")
+	go pu.setStatus("status", "down")
+	go pu.setStatus("reason", "requested")
+	go pu.delStatus("glob")
+}
+
+func (pu *pathUnit) check() {
+	pu.logger.Println("checking up/down state")
+
+	if pu.wantUp {
+		if pu.lockCas == "" {
+			pu.close()
+			if !pu.lockTaken {
+				go pu.mon.tryLock(pu.id)
+			}
+		} else {
+			pu.open()
+		}
+	} else {
+		pu.close()
+		if pu.lockCas != "" {
+			go pu.mon.release(pu.id, pu.lockCas)
+		}
+	}
+}
+
+func (pu *pathUnit) start() {
+	pu.logger.Println("starting")
+	pu.wantUp = true
+	pu.check()
+}
+
+func (pu *pathUnit) stop() {
+	pu.logger.Println("stopping")
+	pu.wantUp = false
+	pu.check()
+}
+
+// Ticks come from forward. A tick finding no change here was either merged
+// into an earlier one or comes from a watch that has since been closed.
+func (pu *pathUnit) tick() {
+	var ev store.Event
+	select {
+	case ev = <-pu.changed:
+	default:
+		return
+	}
+
+	pu.logger.Info("changed", "path", ev.Path, "seqn", ev.Seqn, "service", pu.sv.id)
+	go pu.setStatus("last-trigger", strconv.Uitoa64(ev.Seqn))
+	go pu.setStatus("last-path", ev.Path)
+	pu.sv.activate()
+}
+
+func (pu *pathUnit) dispatchLockEvent(ev store.Event) {
+	pu.logger.Println("got lock event", ev)
+	if ev.Body == pu.mon.self {
+		pu.lockCas, pu.lockTaken = ev.Cas, true
+		go pu.setStatus("node", pu.mon.self)
+	} else {
+		pu.lockCas, pu.lockTaken = "", ev.Body != ""
+	}
+	pu.check()
+}
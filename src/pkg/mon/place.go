@@ -0,0 +1,156 @@
+package mon
+
+import (
+	"doozer/store"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	defInstances = 1
+	claimRetry   = 1e9 // ns to wait after failing to take a lock
+)
+
+type lockFailer interface {
+	lockFailed(path string, err os.Error)
+}
+
+type lockFail struct {
+	l    lockFailer
+	path string
+	err  os.Error
+}
+
+// A service may run as several instances, each on a different node.
+// Instance 0 is locked by /lock/<id>, and instance i > 0 by /lock/<id>@<i>.
+// Its status is likewise kept in /mon/status/<id> or /mon/status/<id>@<i>.
+func instanceId(id string, i int) string {
+	if i == 0 {
+		return id
+	}
+	return id + "@" + strconv.Itoa(i)
+}
+
+// The inverse of instanceId.
+func splitInstance(s string) (id string, i int) {
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return s, 0
+	}
+
+	i, err := strconv.Atoi(s[at+1:])
+	if err != nil || i < 1 {
+		return s, 0
+	}
+	return s[0:at], i
+}
+
+// Reports whether this node may run an instance of the service:
+//
+//   service/instances  how many instances to run in all (default 1)
+//   service/hostname   a pattern that this node's hostname must match
+//   service/tags       tags that this node must have, separated by spaces
+//
+// A node's tags are the value of /doozer/info/<node>/tags, separated by
+// spaces. If this node holds an instance numbered above the count, it may
+// not keep running it.
+func (sv *service) placeable() bool {
+	n, err := lookupInt(sv.mon, sv.id, "service/instances", defInstances)
+	if err != nil || n < 0 {
+		sv.logger.Warn("bad service/instances", "err", err)
+		return false
+	}
+	sv.instances = n
+
+	if sv.instance >= n {
+		return false
+	}
+
+	info := "/doozer/info/" + sv.self + "/"
+	if pat := sv.lookupParam("service/hostname"); pat != "" {
+		host := store.GetString(sv.st, info+"hostname")
+		if ok, _ := path.Match(pat, host); !ok {
+			return false
+		}
+	}
+
+	have := strings.Fields(store.GetString(sv.st, info+"tags"))
+	for _, tag := range strings.Fields(sv.lookupParam("service/tags")) {
+		if !contains(have, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tries to take the lowest-numbered free instance lock, unless a try is
+// already under way. A node holds at most one instance of a service, so
+// instances spread over the nodes that may run them.
+func (sv *service) tryLock() {
+	if sv.claiming != "" {
+		holder := store.GetString(sv.st, sv.claiming)
+		if holder == "" || holder == sv.self {
+			return // no word yet
+		}
+		sv.claiming = "" // someone else got it
+	}
+
+	for i := 0; i < sv.instances; i++ {
+		p := lockDir + instanceId(sv.id, i)
+		if store.GetString(sv.st, p) == "" {
+			sv.claiming = p
+			go sv.claim(instanceId(sv.id, i))
+			return
+		}
+	}
+}
+
+// Tries to take the lock for instance `sid`. If the write fails, the monitor
+// loop is told, so that the claim is not left pending forever.
+func (sv *service) claim(sid string) {
+	err := sv.mon.tryLock(sid)
+	if err != nil {
+		sv.mon.lockCh <- lockFail{sv, lockDir + sid, err}
+	}
+}
+
+func (sv *service) lockFailed(p string, err os.Error) {
+	if p != sv.claiming {
+		return // we have heard about it since
+	}
+
+	sv.logger.Warn("could not take lock", "path", p, "err", err)
+	sv.claiming = ""
+	go sv.mon.timer(sv, claimRetry)
+}
+
+func (sv *service) release() {
+	if sv.lockCas == "" {
+		return
+	}
+
+	go sv.mon.release(instanceId(sv.id, sv.instance), sv.lockCas)
+}
+
+func (sv *service) dispatchLockEvent(ev store.Event) {
+	sv.logger.Println("got lock event", ev)
+	_, i := splitInstance(splitPath(ev.Path))
+	if ev.Path == sv.claiming {
+		sv.claiming = ""
+	}
+
+	switch {
+	case ev.Body == sv.self && (sv.instance < 0 || sv.instance == i):
+		sv.instance, sv.lockCas = i, ev.Cas
+		sv.sid = instanceId(sv.id, i)
+		go sv.setStatus("node", sv.self)
+	case ev.Body == sv.self:
+		// We already hold an instance; give this one back.
+		go sv.mon.release(instanceId(sv.id, i), ev.Cas)
+	case i == sv.instance:
+		sv.instance, sv.lockCas = -1, ""
+	}
+	sv.check()
+}
@@ -0,0 +1,119 @@
+package mon
+
+import (
+	"doozer/store"
+	"github.com/bmizerany/assert"
+	"os"
+	"testing"
+)
+
+func TestInstanceId(t *testing.T) {
+	assert.Equal(t, "a.service", instanceId("a.service", 0))
+	assert.Equal(t, "a.service@2", instanceId("a.service", 2))
+
+	for _, s := range []string{"a.service", "a.service@2", "a@b.service"} {
+		id, i := splitInstance(s)
+		assert.Equal(t, s, instanceId(id, i), s)
+	}
+
+	id, i := splitInstance("a@b.service")
+	assert.Equal(t, "a@b.service", id)
+	assert.Equal(t, 0, i)
+}
+
+func TestPlaceable(t *testing.T) {
+	dt := newDepsTest()
+	sv := newService("a.service", "a", dt.mon)
+	assert.T(t, sv.placeable())
+	assert.Equal(t, 1, sv.instances)
+
+	dt.set("/doozer/info/self/hostname", "web3.example.com")
+	dt.set(defDir+"a.service/service/hostname", "web*.example.com")
+	assert.T(t, sv.placeable())
+
+	dt.set(defDir+"a.service/service/tags", "ssd big")
+	assert.T(t, !sv.placeable())
+
+	dt.set("/doozer/info/self/tags", "big ssd eu")
+	assert.T(t, sv.placeable())
+
+	dt.set(defDir+"a.service/service/hostname", "db*")
+	assert.T(t, !sv.placeable())
+}
+
+func TestPlaceableInstances(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/instances", "2")
+	sv := newService("a.service", "a", dt.mon)
+	sv.instance = 1
+	assert.T(t, sv.placeable())
+
+	dt.set(defDir+"a.service/service/instances", "1")
+	assert.T(t, !sv.placeable())
+}
+
+func TestTryLockPicksFree(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/instances", "3")
+	dt.set(lockDir+"a.service", "other")
+	sv := newService("a.service", "a", dt.mon)
+	sv.placeable()
+
+	sv.tryLock()
+	assert.Equal(t, lockDir+"a.service@1", sv.claiming)
+
+	// No second try while the first is pending.
+	dt.set(lockDir+"a.service@1", "another")
+	sv.claiming = lockDir + "a.service@2"
+	dt.set(lockDir+"a.service@2", "self")
+	sv.tryLock()
+	assert.Equal(t, lockDir+"a.service@2", sv.claiming)
+}
+
+type failSetDeler struct {
+	nopSetDeler
+}
+
+func (failSetDeler) Set(p, body, oldCas string) (string, os.Error) {
+	return "", os.EIO
+}
+
+func TestClaimFails(t *testing.T) {
+	dt := newDepsTest()
+	dt.mon.cl = failSetDeler{}
+	sv := newService("a.service", "a", dt.mon)
+	sv.placeable()
+
+	sv.tryLock()
+	assert.Equal(t, lockDir+"a.service", sv.claiming)
+
+	l := <-dt.mon.lockCh
+	assert.Equal(t, lockDir+"a.service", l.path)
+	assert.Equal(t, os.EIO, l.err)
+
+	// Word of an older claim changes nothing.
+	sv.lockFailed(lockDir+"a.service@1", os.EIO)
+	assert.Equal(t, lockDir+"a.service", sv.claiming)
+
+	l.l.lockFailed(l.path, l.err)
+	assert.Equal(t, "", sv.claiming)
+}
+
+func TestDispatchInstanceLock(t *testing.T) {
+	dt := newDepsTest()
+	sv := newService("a.service", "a", dt.mon)
+
+	sv.dispatchLockEvent(store.Event{Path: lockDir + "a.service@2", Body: "self", Cas: "5"})
+	assert.Equal(t, 2, sv.instance)
+	assert.Equal(t, "5", sv.lockCas)
+	assert.Equal(t, "a.service@2", sv.sid)
+
+	// A second lock is given back, and leaves ours alone.
+	sv.dispatchLockEvent(store.Event{Path: lockDir + "a.service", Body: "self", Cas: "6"})
+	assert.Equal(t, 2, sv.instance)
+	assert.Equal(t, "5", sv.lockCas)
+
+	sv.dispatchLockEvent(store.Event{Path: lockDir + "a.service@2", Cas: store.Missing})
+	assert.Equal(t, -1, sv.instance)
+	assert.Equal(t, "", sv.lockCas)
+}
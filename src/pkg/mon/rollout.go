@@ -0,0 +1,160 @@
+package mon
+
+import (
+	"doozer/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A rollout restarts every instance of a service, a batch at a time. It is
+// begun by writing the batch size (default 1) to /mon/rollout/<id>, and is
+// identified by the cas of that write. Instance i is in batch i/size. An
+// instance restarts once every running instance in the batches before it
+// is done, and is done once it is up again and, if it has a health check,
+// healthy. It fails if that takes longer than "service/rollout-timeout", or
+// if it backs off or is killed as unhealthy on the way. Each instance
+// reports its progress in /mon/status/<instance>/rollout, as
+// "<cas> <state>".
+const (
+	rolloutKey = "/mon/rollout"
+	rolloutDir = rolloutKey + "/"
+
+	defRolloutTimeout = 300 // seconds
+)
+
+// States of an instance in a rollout.
+const (
+	rollRestarting = "restarting"
+	rollDone       = "done"
+	rollFailed     = "failed" // the instance did not come back up
+	rollHalted     = "halted" // an earlier one failed, so this one won't go
+)
+
+// Returns the cas identifying the rollout of service `id` and its batch
+// size, or "" if there is none.
+func (mon *monitor) lookupRollout(id string) (rev string, size int) {
+	v, cas := mon.st.Get(rolloutDir + id)
+	if cas == store.Missing || cas == store.Dir {
+		return "", 0
+	}
+
+	size, err := strconv.Atoi(strings.TrimSpace(v[0]))
+	if err != nil || size < 1 {
+		size = 1
+	}
+	return cas, size
+}
+
+// Returns the state of instance `sid` in rollout `rev`, or "" if it has
+// none.
+func (mon *monitor) rolloutState(sid, rev string) string {
+	s := store.GetString(mon.st, statusDir+sid+"/rollout")
+	if !strings.HasPrefix(s, rev+" ") {
+		return ""
+	}
+	return s[len(rev)+1:]
+}
+
+// Returns rollDone if it is this instance's turn, rollHalted if an earlier
+// instance failed, or "" if some are still going.
+func (sv *service) rolloutTurn(rev string, size int) string {
+	for j := 0; j < sv.instance/size*size; j++ {
+		jid := instanceId(sv.id, j)
+		if store.GetString(sv.st, lockDir+jid) == "" {
+			continue // not running anywhere
+		}
+
+		switch sv.mon.rolloutState(jid, rev) {
+		case rollDone:
+			continue
+		case rollFailed, rollHalted:
+			return rollHalted
+		}
+		return ""
+	}
+	return rollDone
+}
+
+// Takes this instance's next step in the current rollout, if any.
+func (sv *service) checkRollout() {
+	if sv.instance < 0 {
+		return
+	}
+
+	rev, size := sv.mon.lookupRollout(sv.id)
+	if rev == "" {
+		return
+	}
+
+	if rev != sv.rollRev {
+		// Perhaps another node began this instance's part.
+		sv.rollRev, sv.rollState = rev, sv.mon.rolloutState(sv.sid, rev)
+		if sv.rollState == rollRestarting && !sv.startRollDeadline() {
+			return
+		}
+	}
+
+	switch sv.rollState {
+	case "":
+		switch sv.rolloutTurn(rev, size) {
+		case rollHalted:
+			sv.setRollState(rollHalted)
+		case rollDone:
+			if sv.pid == 0 {
+				sv.setRollState(rollDone) // nothing to restart
+				break
+			}
+			if !sv.startRollDeadline() {
+				break
+			}
+			sv.logger.Info("restarting for rollout", "rollout", rev)
+			sv.setRollState(rollRestarting)
+			sv.restartNow()
+		}
+	case rollRestarting:
+		switch {
+		case !sv.wantUp, sv.unhealthy, sv.holdUntil > time.Nanoseconds():
+			sv.setRollState(rollFailed)
+		case time.Nanoseconds() >= sv.rollDeadline:
+			sv.logger.Warn("rollout timed out", "rollout", rev)
+			sv.setRollState(rollFailed)
+		case sv.pid == 0 && !sv.restarting && sv.so != nil && sv.so.lfiles != nil:
+			// Its socket is open, and it will start on activity.
+			sv.setRollState(rollDone)
+		case sv.pid != 0 && !sv.restarting && sv.killing != sv.pid:
+			if sv.hc == nil || sv.healthMsg == "ok" {
+				sv.setRollState(rollDone)
+			}
+		}
+	}
+}
+
+// Reads "service/rollout-timeout" and arranges to check the rollout again
+// when it is up. Returns false, having failed this instance's part, if the
+// timeout is bad.
+func (sv *service) startRollDeadline() bool {
+	timeout, err := lookupSeconds(sv.mon, sv.id, "service/rollout-timeout", defRolloutTimeout)
+	if err != nil {
+		sv.logger.Warn("bad rollout timeout", "err", err)
+		sv.setRollState(rollFailed)
+		return false
+	}
+
+	sv.rollDeadline = time.Nanoseconds() + timeout
+	go sv.mon.timer(sv, timeout)
+	return true
+}
+
+func (sv *service) setRollState(s string) {
+	sv.rollState = s
+	go sv.setStatus("rollout", sv.rollRev+" "+s)
+}
+
+// Called when the rollout state of instance `sid` changes.
+func (mon *monitor) rolloutChanged(sid string) {
+	id, _ := splitInstance(sid)
+	if sv, ok := mon.units[id].(*service); ok {
+		sv.checkRollout()
+	}
+}
@@ -0,0 +1,144 @@
+package mon
+
+import (
+	"github.com/bmizerany/assert"
+	"os"
+	"testing"
+)
+
+func TestLookupRollout(t *testing.T) {
+	dt := newDepsTest()
+	rev, _ := dt.mon.lookupRollout("a.service")
+	assert.Equal(t, "", rev)
+
+	dt.set(rolloutDir+"a.service", "2")
+	rev, size := dt.mon.lookupRollout("a.service")
+	assert.NotEqual(t, "", rev)
+	assert.Equal(t, 2, size)
+
+	dt.set(rolloutDir+"a.service", "")
+	_, size = dt.mon.lookupRollout("a.service")
+	assert.Equal(t, 1, size)
+}
+
+func TestRolloutTurn(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(rolloutDir+"a.service", "1")
+	rev, _ := dt.mon.lookupRollout("a.service")
+
+	sv := newService("a.service", "a", dt.mon)
+	sv.instance = 2
+
+	// Instance 1 runs nowhere, so only instance 0 counts.
+	dt.set(lockDir+"a.service", "other")
+	assert.Equal(t, "", sv.rolloutTurn(rev, 1))
+
+	dt.set(statusDir+"a.service/rollout", rev+" restarting")
+	assert.Equal(t, "", sv.rolloutTurn(rev, 1))
+
+	dt.set(statusDir+"a.service/rollout", rev+" done")
+	assert.Equal(t, rollDone, sv.rolloutTurn(rev, 1))
+
+	dt.set(statusDir+"a.service/rollout", rev+" failed")
+	assert.Equal(t, rollHalted, sv.rolloutTurn(rev, 1))
+
+	// In a batch of 3, instance 2 goes along with instance 0.
+	dt.set(statusDir+"a.service/rollout", "")
+	assert.Equal(t, rollDone, sv.rolloutTurn(rev, 3))
+}
+
+func TestCheckRolloutNotRunning(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(rolloutDir+"a.service", "1")
+	rev, _ := dt.mon.lookupRollout("a.service")
+
+	sv := newService("a.service", "a", dt.mon)
+	sv.checkRollout()
+	assert.Equal(t, "", sv.rollState) // holds no instance
+
+	sv.instance = 0
+	sv.checkRollout()
+	assert.Equal(t, rev, sv.rollRev)
+	assert.Equal(t, rollDone, sv.rollState)
+}
+
+func TestCheckRolloutRestarting(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(rolloutDir+"a.service", "1")
+	rev, _ := dt.mon.lookupRollout("a.service")
+
+	sv := newService("a.service", "a", dt.mon)
+	sv.instance, sv.wantUp, sv.pid = 0, true, 5
+	sv.rollRev, sv.rollState = rev, rollRestarting
+	sv.rollDeadline = 1 << 62
+	sv.hc = &healthCheck{}
+	sv.checkRollout()
+	assert.Equal(t, rollRestarting, sv.rollState) // not healthy yet
+
+	sv.healthMsg = "ok"
+	sv.checkRollout()
+	assert.Equal(t, rollDone, sv.rollState)
+
+	sv.rollState, sv.wantUp = rollRestarting, false
+	sv.checkRollout()
+	assert.Equal(t, rollFailed, sv.rollState)
+}
+
+func newRestartingService(dt *depsTest) *service {
+	dt.set(rolloutDir+"a.service", "1")
+	rev, _ := dt.mon.lookupRollout("a.service")
+
+	sv := newService("a.service", "a", dt.mon)
+	sv.instance, sv.wantUp = 0, true
+	sv.rollRev, sv.rollState = rev, rollRestarting
+	sv.rollDeadline = 1 << 62
+	return sv
+}
+
+func TestCheckRolloutTimeout(t *testing.T) {
+	dt := newDepsTest()
+	sv := newRestartingService(dt)
+	sv.hc = &healthCheck{}
+	sv.pid = 5
+	sv.checkRollout()
+	assert.Equal(t, rollRestarting, sv.rollState) // never healthy
+
+	sv.rollDeadline = 1
+	sv.checkRollout()
+	assert.Equal(t, rollFailed, sv.rollState)
+}
+
+func TestCheckRolloutBackoff(t *testing.T) {
+	dt := newDepsTest()
+	sv := newRestartingService(dt)
+	sv.holdUntil = 1 << 62 // crashed, and will start again later
+	sv.checkRollout()
+	assert.Equal(t, rollFailed, sv.rollState)
+}
+
+func TestCheckRolloutUnhealthy(t *testing.T) {
+	dt := newDepsTest()
+	sv := newRestartingService(dt)
+	sv.pid, sv.unhealthy = 5, true
+	sv.checkRollout()
+	assert.Equal(t, rollFailed, sv.rollState)
+}
+
+func TestCheckRolloutSocket(t *testing.T) {
+	dt := newDepsTest()
+	sv := newRestartingService(dt)
+	sv.so = &socket{lfiles: []*os.File{}}
+	sv.checkRollout()
+	assert.Equal(t, rollDone, sv.rollState)
+}
+
+func TestCheckRolloutBadTimeout(t *testing.T) {
+	dt := newDepsTest()
+	dt.set(defDir+"a.service/service/rollout-timeout", "soon")
+	dt.set(rolloutDir+"a.service", "1")
+
+	sv := newService("a.service", "a", dt.mon)
+	sv.instance, sv.pid = 0, 5
+	sv.checkRollout()
+	assert.Equal(t, rollFailed, sv.rollState)
+}
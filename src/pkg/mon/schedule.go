@@ -0,0 +1,179 @@
+package mon
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A schedule says when a timer unit fires.
+type schedule interface {
+	// Returns the first time strictly after `t` at which the schedule
+	// fires, in ns since the epoch, or -1 if there is none.
+	next(t int64) int64
+}
+
+// Fires every `ns` nanoseconds.
+type interval int64
+
+func (iv interval) next(t int64) int64 {
+	return t + int64(iv)
+}
+
+// Fires at the start of each minute that matches, in the manner of cron(8).
+// Times are in UTC, so every node in the cluster agrees on them.
+type calendar struct {
+	min, hour, dom, mon, dow uint64 // bit i set iff value i matches
+	anyDom, anyDow           bool
+}
+
+var calendarFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// Parses a cron-like calendar spec of five fields: minute, hour, day of
+// month, month and day of week (0 is Sunday). Each field is a
+// comma-separated list of items, where an item is "*", a number, or a range
+// "a-b", optionally followed by a step "/n".
+func parseCalendar(s string) (*calendar, os.Error) {
+	fields := strings.Fields(s)
+	if len(fields) != len(calendarFields) {
+		return nil, os.NewError("calendar: want 5 fields, got " + strconv.Itoa(len(fields)))
+	}
+
+	var bits [5]uint64
+	for i, f := range fields {
+		cf := calendarFields[i]
+		b, err := parseCalendarField(f, cf.min, cf.max)
+		if err != nil {
+			return nil, os.NewError("calendar: bad " + cf.name + ": " + err.String())
+		}
+		bits[i] = b
+	}
+
+	return &calendar{
+		min:    bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		mon:    bits[3],
+		dow:    bits[4],
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}, nil
+}
+
+func parseCalendarField(f string, min, max int) (bits uint64, err os.Error) {
+	for _, item := range strings.Split(f, ",", -1) {
+		lo, hi, step := min, max, 1
+
+		if i := strings.Index(item, "/"); i >= 0 {
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return 0, os.NewError(item)
+			}
+			item = item[0:i]
+		}
+
+		switch i := strings.Index(item, "-"); {
+		case item == "*":
+			// whole range
+		case i >= 0:
+			lo, err = strconv.Atoi(item[0:i])
+			if err != nil {
+				return 0, os.NewError(item)
+			}
+			hi, err = strconv.Atoi(item[i+1:])
+			if err != nil {
+				return 0, os.NewError(item)
+			}
+		default:
+			lo, err = strconv.Atoi(item)
+			if err != nil {
+				return 0, os.NewError(item)
+			}
+			if step == 1 {
+				hi = lo
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, os.NewError(item)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (c *calendar) matchDay(t *time.Time) bool {
+	dom, dow := has(c.dom, t.Day), has(c.dow, t.Weekday)
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow // as in cron, either one will do
+}
+
+// We look at most this far ahead. Every valid spec fires within four years
+// (the 29th of February is the rarest day there is).
+const calendarHorizon = 4 * 366 * 24 * 60 * 60
+
+func (c *calendar) next(t int64) int64 {
+	s := t / 1e9
+	s = s - s%60 + 60 // start of the next minute
+	end := s + calendarHorizon
+
+	for s < end {
+		tm := time.SecondsToUTC(s)
+		switch {
+		case !has(c.mon, tm.Month) || !c.matchDay(tm):
+			s += int64(24*60*60 - tm.Hour*60*60 - tm.Minute*60)
+		case !has(c.hour, tm.Hour):
+			s += int64(60*60 - tm.Minute*60)
+		case !has(c.min, tm.Minute):
+			s += 60
+		default:
+			return s * 1e9
+		}
+	}
+	return -1
+}
+
+// Reads the schedule of timer unit `id`. Exactly one of "timer/interval"
+// (in seconds) and "timer/calendar" must be set.
+func lookupSchedule(mon *monitor, id string) (schedule, os.Error) {
+	iv := mon.lookupParam(id, "timer/interval")
+	cal := mon.lookupParam(id, "timer/calendar")
+
+	switch {
+	case iv != "" && cal != "":
+		return nil, os.NewError("timer: both interval and calendar are set")
+	case iv != "":
+		n, err := strconv.Atoi64(iv)
+		if err != nil || n < 1 {
+			return nil, os.NewError("timer: bad interval: " + iv)
+		}
+		return interval(n * 1e9), nil
+	case cal != "":
+		return parseCalendar(cal)
+	}
+	return nil, os.NewError("timer: no schedule")
+}
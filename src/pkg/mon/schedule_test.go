@@ -0,0 +1,83 @@
+package mon
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+	"time"
+)
+
+// Returns ns since the epoch for the given UTC time.
+func utc(year int64, month, day, hour, min int) int64 {
+	t := &time.Time{Year: year, Month: month, Day: day, Hour: hour, Minute: min}
+	return t.Seconds() * 1e9
+}
+
+func TestInterval(t *testing.T) {
+	assert.Equal(t, int64(15e9), interval(10e9).next(5e9))
+}
+
+var calendarNexts = []struct {
+	spec     string
+	from, to int64
+}{
+	// Every minute.
+	{"* * * * *", utc(2011, 3, 1, 12, 0), utc(2011, 3, 1, 12, 1)},
+
+	// Strictly after, even on a match.
+	{"30 * * * *", utc(2011, 3, 1, 12, 30), utc(2011, 3, 1, 13, 30)},
+	{"30 * * * *", utc(2011, 3, 1, 12, 29) + 59e9, utc(2011, 3, 1, 12, 30)},
+
+	// Steps, ranges and lists.
+	{"*/15 * * * *", utc(2011, 3, 1, 12, 16), utc(2011, 3, 1, 12, 30)},
+	{"0 9-17/4 * * *", utc(2011, 3, 1, 13, 1), utc(2011, 3, 1, 17, 0)},
+	{"5,50 * * * *", utc(2011, 3, 1, 12, 6), utc(2011, 3, 1, 12, 50)},
+
+	// Rolling over the day, month and year.
+	{"0 0 * * *", utc(2011, 12, 31, 23, 59), utc(2012, 1, 1, 0, 0)},
+	{"0 0 1 * *", utc(2011, 3, 2, 0, 0), utc(2011, 4, 1, 0, 0)},
+
+	// 2011-03-01 is a Tuesday.
+	{"0 0 * * 0", utc(2011, 3, 1, 0, 0), utc(2011, 3, 6, 0, 0)},
+
+	// Day of month or day of week, as in cron.
+	{"0 0 4 * 0", utc(2011, 3, 1, 0, 0), utc(2011, 3, 4, 0, 0)},
+
+	// Leap day.
+	{"0 0 29 2 *", utc(2011, 1, 1, 0, 0), utc(2012, 2, 29, 0, 0)},
+}
+
+func TestCalendarNext(t *testing.T) {
+	for _, c := range calendarNexts {
+		cal, err := parseCalendar(c.spec)
+		assert.Equal(t, nil, err, c.spec)
+		assert.Equal(t, c.to, cal.next(c.from), c.spec)
+	}
+}
+
+func TestCalendarNever(t *testing.T) {
+	cal, err := parseCalendar("0 0 31 2 *")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(-1), cal.next(utc(2011, 1, 1, 0, 0)))
+}
+
+var badCalendars = []string{
+	"",
+	"* * * *",
+	"* * * * * *",
+	"60 * * * *",
+	"* 24 * * *",
+	"* * 0 * *",
+	"* * * 13 *",
+	"* * * * 7",
+	"*/0 * * * *",
+	"5-1 * * * *",
+	"a * * * *",
+	"1,,2 * * * *",
+}
+
+func TestCalendarBad(t *testing.T) {
+	for _, s := range badCalendars {
+		_, err := parseCalendar(s)
+		assert.NotEqual(t, nil, err, s)
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"doozer/exec"
 	"doozer/store"
 	"doozer/util"
-	"log"
 	"os"
 	"strconv"
 	"syscall"
+	"time"
 )
 
 const (
@@ -23,32 +23,83 @@ var restart = map[string]int{
 	"restart-always":     restartAlways,
 }
 
+// Defaults for the restart parameters. Times are in seconds.
+const (
+	defRestartDelay    = 0.1
+	defRestartDelayMax = 60
+	defRestartInterval = 10
+	defRestartBurst    = 5
+)
+
 type service struct {
-	id, name  string
-	pid       int
-	st        *store.Store
-	self      string
-	cl        SetDeler
-	logger    *log.Logger
-	mon       *monitor
-	wantUp    bool
-	lockCas   string
-	lockTaken bool
-	restart   int
-	alfiles   []*os.File
-	cx        exec.Context
-	so        *socket
+	id, name string
+	pid      int
+	st       *store.Store
+	self     string
+	cl       SetDeler
+	logger   *util.Logger
+	mon      *monitor
+	wantUp   bool
+	restart  int
+	alfiles  []*os.File
+	cx       exec.Context
+	so       *socket
+
+	// This node runs at most one of the "service/instances" instances.
+	// Status is reported under sid, the id of the instance last held.
+	instances int
+	instance  int    // the one whose lock we hold, or -1
+	lockCas   string // of that lock
+	claiming  string // lock we have tried to take and not heard back about
+	sid       string
+
+	rollRev, rollState string // our part in the current rollout
+	rollDeadline       int64  // when restarting for it counts as failed
+
+	// Restarts are delayed by `delay`, which doubles with each restart, up
+	// to "service/restart-delay-max". It goes back to
+	// "service/restart-delay" once the service stays up for
+	// "service/restart-interval". More than "service/restart-burst" starts
+	// in that interval is a failure.
+	delay, delayMin, delayMax int64
+	window                    int64
+	burst                     int
+	starts                    []int64 // recent start times
+	holdUntil                 int64   // don't start again before this
+	holding                   bool    // a tick is due at holdUntil
+
+	// Stopping runs "service/exec-stop", if set, and waits for it to
+	// finish. Then it sends stopSignal, and SIGKILL if the process is still
+	// there stopTimeout after the stop began.
+	stopSignal  int
+	stopTimeout int64
+	killing     int   // pid being stopped
+	killAt      int64 // when to send it SIGKILL
+	stopPid     int   // of the running exec-stop command
+	signalled   bool  // killing has been sent stopSignal
+	restarting  bool  // start again once stopped
+
+	logs    *serviceLogs
+	errDone chan bool // closed when all of stderr is in logs
+
+	hc        *healthCheck
+	hcDone    chan bool
+	hcFails   int
+	healthMsg string // as last reported
+	unhealthy bool   // killed for failing its health check
 }
 
 func newService(id, name string, mon *monitor) *service {
 	sv := &service{
-		id:     id,
-		name:   name,
-		st:     mon.st,
-		self:   mon.self,
-		cl:     mon.cl,
-		mon:    mon,
-		logger: util.NewLogger(id),
+		id:       id,
+		name:     name,
+		st:       mon.st,
+		self:     mon.self,
+		cl:       mon.cl,
+		mon:      mon,
+		logger:   util.NewLogger("mon").With("unit", id),
+		instance: -1,
+		sid:      id,
 	}
 	sv.logger.Println("new")
 	return sv
@@ -63,16 +114,16 @@ func (sv *service) setActiveLFDs(alfiles []*os.File) {
 	sv.check()
 }
 
-func (sv *service) tryLock() {
-	sv.mon.tryLock(sv.id)
-}
-
-func (sv *service) release() {
-	if sv.lockCas == "" {
+// Runs the service once, on behalf of a unit with no listen fds to pass it,
+// such as a timer. Does nothing if the service is already running here.
+func (sv *service) activate() {
+	if sv.pid != 0 {
+		sv.logger.Info("already running, not activating")
 		return
 	}
 
-	go sv.mon.release(sv.id, sv.lockCas)
+	sv.wantUp = true
+	sv.setActiveLFDs([]*os.File{})
 }
 
 func (sv *service) lookupRestart() (int, os.Error) {
@@ -83,12 +134,78 @@ func (sv *service) lookupRestart() (int, os.Error) {
 	return r, nil
 }
 
+// Reads the parameters for restarts and health checks.
+func (sv *service) lookupBackoff() (err os.Error) {
+	sv.delayMin, err = lookupSeconds(sv.mon, sv.id, "service/restart-delay", defRestartDelay)
+	if err != nil {
+		return err
+	}
+	sv.delayMax, err = lookupSeconds(sv.mon, sv.id, "service/restart-delay-max", defRestartDelayMax)
+	if err != nil {
+		return err
+	}
+	sv.window, err = lookupSeconds(sv.mon, sv.id, "service/restart-interval", defRestartInterval)
+	if err != nil {
+		return err
+	}
+	sv.burst, err = lookupInt(sv.mon, sv.id, "service/restart-burst", defRestartBurst)
+	if err != nil {
+		return err
+	}
+	sv.hc, err = lookupHealthCheck(sv.mon, sv.id, sv.cx)
+	return err
+}
+
+// Returns true iff the service must wait before it starts again, and
+// arranges to check again when the wait is over.
+func (sv *service) backingOff() bool {
+	wait := sv.holdUntil - time.Nanoseconds()
+	if wait <= 0 {
+		return false
+	}
+
+	if !sv.holding {
+		sv.holding = true
+		sv.logger.Info("backing off", "seconds", float64(wait)/1e9)
+		go sv.setStatus("status", "backoff")
+		go sv.mon.timer(sv, wait)
+	}
+	return true
+}
+
+// Records a start now. Returns false iff there have been too many.
+func (sv *service) countStart() bool {
+	now := time.Nanoseconds()
+	recent := sv.starts[0:0]
+	for _, t := range sv.starts {
+		if now-t < sv.window {
+			recent = append(recent, t)
+		}
+	}
+	sv.starts = append(recent, now)
+	return len(sv.starts) <= sv.burst
+}
+
+// Called when a process that ran for `ran` ns is to be restarted.
+func (sv *service) backOff(ran int64) {
+	switch {
+	case sv.delay == 0 || ran >= sv.window:
+		sv.delay = sv.delayMin
+	case sv.delay < sv.delayMax/2:
+		sv.delay *= 2
+	default:
+		sv.delay = sv.delayMax
+	}
+	sv.holdUntil = time.Nanoseconds() + sv.delay
+}
+
 func (sv *service) exec() {
-	if sv.pid != 0 {
+	if sv.pid != 0 || sv.backingOff() {
 		return
 	}
 
 	var err os.Error
+	var ow, ew *os.File
 
 	sv.logger.Println("exec")
 	sv.setStatus("status", "starting") // do it synchronously
@@ -99,8 +216,41 @@ func (sv *service) exec() {
 		goto error
 	}
 
+	sv.cx, err = lookupContext(sv.mon, sv.id)
+	if err != nil {
+		goto error
+	}
+
+	err = sv.lookupStop()
+	if err != nil {
+		goto error
+	}
+
+	err = sv.lookupBackoff()
+	if err != nil {
+		goto error
+	}
+
+	if !sv.countStart() {
+		err = os.NewError("started too often")
+		goto error
+	}
+
+	sv.logs = sv.mon.openLogs(sv.id)
+	ow, _, err = sv.logs.stdout.pipe()
+	if err != nil {
+		goto error
+	}
+	ew, sv.errDone, err = sv.logs.stderr.pipe()
+	if err != nil {
+		ow.Close()
+		goto error
+	}
+
 	sv.logger.Println("*** *** *** RUN *** *** ***")
-	sv.pid, err = sv.cx.ForkExec(cmd, sv.alfiles)
+	sv.pid, err = sv.cx.ForkExecOutput(cmd, sv.alfiles, ow, ew)
+	ow.Close()
+	ew.Close()
 	if err != nil {
 		goto error
 	}
@@ -115,21 +265,101 @@ func (sv *service) exec() {
 	go sv.delStatus("reason")
 	go sv.setStatus("pid", strconv.Itoa(sv.pid))
 	go sv.mon.wait(sv.pid, sv)
+
+	if sv.hc != nil {
+		sv.hcDone, sv.hcFails, sv.unhealthy = make(chan bool), 0, false
+		go sv.mon.checkHealth(sv.hc, sv.pid, sv, sv.hcDone)
+	}
+	sv.checkRollout()
 	return
 
 error:
 	sv.wantUp = false // fatal error -- don't retry
 	sv.logger.Println(err)
-	go sv.setStatus("status", "down")
+	go sv.setStatus("status", "failed")
 	go sv.setStatus("reason", err.String())
+	sv.checkRollout()
 }
 
+// Reads "service/stop-signal" and "service/stop-timeout" (in seconds).
+func (sv *service) lookupStop() (err os.Error) {
+	sv.stopSignal = defStopSignal
+	if s := sv.lookupParam("service/stop-signal"); s != "" {
+		sv.stopSignal, err = parseSignal(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	sv.stopTimeout, err = lookupSeconds(sv.mon, sv.id, "service/stop-timeout", defStopTimeout)
+	return err
+}
+
+// Stops the process gracefully, as described for the stop fields of
+// service. It is called again as things happen, to take the next step.
 func (sv *service) kill() {
 	if sv.pid == 0 {
 		return
 	}
 
-	errno := syscall.Kill(sv.pid, syscall.SIGTERM)
+	if sv.killing != sv.pid {
+		sv.logger.Info("stopping", "pid", sv.pid)
+		sv.killing, sv.killAt = sv.pid, time.Nanoseconds()+sv.stopTimeout
+		sv.signalled = false
+		go sv.setStatus("status", "stopping")
+		go sv.mon.timer(sv, sv.stopTimeout)
+		sv.execStop()
+	}
+
+	switch {
+	case time.Nanoseconds() >= sv.killAt:
+		sv.logger.Warn("did not stop in time, killing", "pid", sv.pid)
+		sv.signal(sv.pid, syscall.SIGKILL)
+		if sv.stopPid != 0 {
+			sv.signal(sv.stopPid, syscall.SIGKILL)
+		}
+	case sv.stopPid == 0 && !sv.signalled:
+		sv.signalled = true
+		sv.signal(sv.pid, sv.stopSignal)
+	}
+}
+
+// Starts "service/exec-stop", if there is one. Its output goes with that of
+// the service, and MAINPID in its environment is the pid being stopped. If
+// it can't be started, the service gets stopSignal straight away.
+func (sv *service) execStop() {
+	cmd := sv.lookupParam("service/exec-stop")
+	if cmd == "" {
+		return
+	}
+
+	cx := sv.cx
+	cx.Env = append(append([]string(nil), sv.cx.Env...), "MAINPID="+strconv.Itoa(sv.pid))
+
+	ow, _, err := sv.logs.stdout.pipe()
+	if err != nil {
+		sv.logger.Warn("cannot run exec-stop", "err", err)
+		return
+	}
+	ew, _, err := sv.logs.stderr.pipe()
+	if err != nil {
+		ow.Close()
+		sv.logger.Warn("cannot run exec-stop", "err", err)
+		return
+	}
+
+	sv.stopPid, err = cx.ForkExecOutput(cmd, nil, ow, ew)
+	ow.Close()
+	ew.Close()
+	if err != nil {
+		sv.logger.Warn("cannot run exec-stop", "err", err)
+		return
+	}
+	go sv.mon.wait(sv.stopPid, sv)
+}
+
+func (sv *service) signal(pid, sig int) {
+	errno := syscall.Kill(pid, sig)
 	if errno != 0 {
 		sv.logger.Println(os.Errno(errno))
 	}
@@ -140,11 +370,11 @@ func (sv *service) lookupParam(param string) string {
 }
 
 func (sv *service) setStatus(param, val string) {
-	sv.mon.setStatus(sv.id, param, val)
+	sv.mon.setStatus(sv.sid, param, val)
 }
 
 func (sv *service) delStatus(param string) {
-	sv.mon.delStatus(sv.id, param)
+	sv.mon.delStatus(sv.sid, param)
 }
 
 func exitedCleanly(w *os.Waitmsg) bool {
@@ -162,20 +392,64 @@ func (sv *service) isFatal(w *os.Waitmsg) bool {
 }
 
 func (sv *service) exited(w *os.Waitmsg) {
+	if w.Pid == sv.stopPid {
+		sv.stopPid = 0
+		if !exitedCleanly(w) {
+			sv.logger.Warn("exec-stop failed", "status", w)
+		}
+		sv.kill() // on to the signal
+		return
+	}
+
 	if w.Pid != sv.pid {
 		return
 	}
-	sv.pid = 0
+	sv.pid, sv.stopPid = 0, 0
 
 	sv.logger.Println(w)
 	go sv.delStatus("pid")
 
-	if sv.isFatal(w) {
+	if sv.hcDone != nil {
+		close(sv.hcDone)
+		sv.hcDone = nil
+		sv.setHealth("")
+	}
+
+	go sv.reportTail(sv.logs.stderr, sv.errDone)
+
+	reason := w.String()
+	if sv.unhealthy {
+		reason = "unhealthy: " + reason
+	}
+
+	status := "down"
+	if sv.restarting {
+		sv.restarting, sv.holdUntil = false, 0
+		reason = "restarted"
+		if sv.so == nil {
+			sv.alfiles = []*os.File{}
+		}
+	} else if sv.isFatal(w) {
 		sv.wantUp = false
 		sv.logger.Println("fatal error")
+		if !exitedCleanly(w) {
+			status = "failed"
+		}
+	} else if sv.wantUp {
+		var ran int64
+		if n := len(sv.starts); n > 0 {
+			ran = time.Nanoseconds() - sv.starts[n-1]
+		}
+		sv.backOff(ran)
+
+		// A service with a socket is started again on activity. Others
+		// are started again right away.
+		if sv.so == nil {
+			sv.alfiles = []*os.File{}
+		}
 	}
-	go sv.setStatus("status", "down")
-	go sv.setStatus("reason", w.String())
+	go sv.setStatus("status", status)
+	go sv.setStatus("reason", reason)
 
 	if sv.so != nil {
 		sv.so.exited()
@@ -186,28 +460,67 @@ func (sv *service) exited(w *os.Waitmsg) {
 
 func (sv *service) check() {
 	sv.logger.Println("checking up/down state")
+	sv.checkRollout()
 
-	if sv.wantUp && sv.alfiles != nil {
+	if sv.wantUp && sv.alfiles != nil && sv.placeable() {
 		if sv.lockCas == "" {
 			sv.kill()
-			if !sv.lockTaken {
-				go sv.tryLock()
-			}
+			sv.tryLock()
 		} else {
 			sv.exec()
 		}
 	} else {
 		if sv.pid != 0 {
 			sv.kill()
-			go sv.mon.timer(sv, 1000)
 		} else {
 			sv.release()
 		}
 	}
 }
 
+// Called with the result of a health check of process `pid`.
+func (sv *service) health(pid int, err os.Error) {
+	if pid != sv.pid || sv.hc == nil {
+		return
+	}
+
+	if err == nil {
+		sv.hcFails = 0
+		sv.setHealth("ok")
+		sv.checkRollout()
+		return
+	}
+
+	sv.hcFails++
+	sv.logger.Warn("health check failed", "err", err, "failures", sv.hcFails)
+	sv.setHealth("failing: " + err.String())
+	if sv.hcFails >= sv.hc.failures && !sv.unhealthy {
+		sv.logger.Warn("unhealthy, killing")
+		sv.unhealthy = true
+		sv.kill()
+		sv.checkRollout()
+	}
+}
+
+func (sv *service) setHealth(s string) {
+	if s == sv.healthMsg {
+		return
+	}
+
+	sv.healthMsg = s
+	if s == "" {
+		go sv.delStatus("health")
+	} else {
+		go sv.setStatus("health", s)
+	}
+}
+
 func (sv *service) start() {
 	sv.logger.Println("starting")
+	if !sv.wantUp {
+		// Asked to start afresh, so forget about earlier trouble.
+		sv.starts, sv.delay, sv.holdUntil = nil, 0, 0
+	}
 	sv.wantUp = true
 	sv.check()
 }
@@ -218,17 +531,19 @@ func (sv *service) stop() {
 	sv.check()
 }
 
-func (sv *service) tick() {
-	sv.check()
+// Stops the process gracefully and starts it again straight away.
+func (sv *service) restartNow() {
+	sv.logger.Println("restarting")
+	sv.wantUp, sv.restarting = true, true
+	sv.kill()
 }
 
-func (sv *service) dispatchLockEvent(ev store.Event) {
-	sv.logger.Println("got lock event", ev)
-	if ev.Body == sv.self {
-		sv.lockCas, sv.lockTaken = ev.Cas, true
-		go sv.setStatus("node", sv.self)
-	} else {
-		sv.lockCas, sv.lockTaken = "", ev.Body != ""
+func (sv *service) tick() {
+	if sv.holding && time.Nanoseconds() >= sv.holdUntil {
+		sv.holding = false
+	}
+	if sv.pid != 0 && sv.killing == sv.pid {
+		sv.kill() // escalate, if it's time
 	}
 	sv.check()
 }
@@ -0,0 +1,46 @@
+package mon
+
+import (
+	"github.com/bmizerany/assert"
+	"os"
+	"testing"
+)
+
+func newStoppingService(dt *depsTest) *service {
+	sv := newService("a.service", "a", dt.mon)
+	sv.logs = &serviceLogs{new(output), new(output)}
+	sv.pid, sv.killing, sv.signalled = 5, 5, true
+	sv.killAt = 1 << 62 // not due for SIGKILL
+	return sv
+}
+
+func TestRestartStartsAgain(t *testing.T) {
+	dt := newDepsTest()
+	sv := newStoppingService(dt)
+	sv.wantUp, sv.restarting = true, true
+	sv.holdUntil = 1 << 62
+
+	sv.exited(&os.Waitmsg{Pid: 5})
+	assert.Equal(t, 0, sv.pid)
+	assert.T(t, sv.wantUp)
+	assert.T(t, !sv.restarting)
+	assert.Equal(t, int64(0), sv.holdUntil)
+	assert.NotEqual(t, ([]*os.File)(nil), sv.alfiles)
+}
+
+func TestExecStopExit(t *testing.T) {
+	dt := newDepsTest()
+	sv := newStoppingService(dt)
+	sv.stopPid = 7
+
+	// The stop command is not the service.
+	sv.exited(&os.Waitmsg{Pid: 7})
+	assert.Equal(t, 0, sv.stopPid)
+	assert.Equal(t, 5, sv.pid)
+
+	// Once the service exits, a late stop command is forgotten.
+	sv.stopPid = 8
+	sv.exited(&os.Waitmsg{Pid: 5})
+	assert.Equal(t, 0, sv.stopPid)
+	assert.Equal(t, 0, sv.pid)
+}
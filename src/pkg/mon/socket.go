@@ -3,9 +3,11 @@ package mon
 import (
 	"doozer/store"
 	"doozer/util"
-	"log"
+	"io"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type socket struct {
@@ -14,8 +16,10 @@ type socket struct {
 	st        *store.Store
 	self      string
 	cl        SetDeler
-	logger    *log.Logger
-	lfiles    []*os.File
+	logger    *util.Logger
+	lfiles    []*os.File // passed to the service, in order
+	conns     []io.Closer
+	paths     []string // of unix datagram sockets
 	mon       *monitor
 	wantUp    bool
 	lockCas   string
@@ -41,7 +45,7 @@ func newSocket(id, name string, mon *monitor) *socket {
 		cl:     mon.cl,
 		sv:     sv,
 		mon:    mon,
-		logger: util.NewLogger(id),
+		logger: util.NewLogger("mon").With("unit", id),
 	}
 	so.logger.Println("new")
 	return so
@@ -67,6 +71,117 @@ func (so *socket) lookupRestart() (int, os.Error) {
 	return r, nil
 }
 
+// An address a socket unit listens on. The network is "tcp", "udp", "unix"
+// or "unixgram".
+type listenAddr struct {
+	net, addr string
+}
+
+const defSocketMode = 0666
+
+// Reads the addresses the socket listens on, stream ones first:
+//
+//   socket/listen-stream    TCP addresses, or paths of unix stream sockets
+//   socket/listen-datagram  UDP addresses, or paths of unix datagram sockets
+//
+// Each holds a list separated by spaces. Paths begin with "/".
+func (so *socket) lookupAddrs() (las []listenAddr) {
+	for _, s := range strings.Fields(so.lookupParam("socket/listen-stream")) {
+		if strings.HasPrefix(s, "/") {
+			las = append(las, listenAddr{"unix", s})
+		} else {
+			las = append(las, listenAddr{"tcp", s})
+		}
+	}
+
+	for _, s := range strings.Fields(so.lookupParam("socket/listen-datagram")) {
+		if strings.HasPrefix(s, "/") {
+			las = append(las, listenAddr{"unixgram", s})
+		} else {
+			las = append(las, listenAddr{"udp", s})
+		}
+	}
+	return las
+}
+
+// Reads "socket/socket-mode", the permissions of unix socket files, in
+// octal.
+func (so *socket) lookupMode() (uint32, os.Error) {
+	s := so.lookupParam("socket/socket-mode")
+	if s == "" {
+		return defSocketMode, nil
+	}
+
+	mode, err := strconv.Btoui64(s, 8)
+	if err != nil || mode > 0777 {
+		return 0, os.NewError("socket/socket-mode: bad mode: " + s)
+	}
+	return uint32(mode), nil
+}
+
+// Opens a socket to listen on `la`. Returns it, a file for its fd to pass
+// to the service, and the address it is bound to.
+func listen(la listenAddr, mode uint32) (c io.Closer, file *os.File, addr string, err os.Error) {
+	isPath := la.net == "unix" || la.net == "unixgram"
+	if isPath {
+		err = removeSocket(la.addr)
+		if err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	switch la.net {
+	case "tcp", "unix":
+		var li net.Listener
+		li, err = net.Listen(la.net, la.addr)
+		if err == nil {
+			c, addr = li, li.Addr().String()
+		}
+	default:
+		var pc net.PacketConn
+		pc, err = net.ListenPacket(la.net, la.addr)
+		if err == nil {
+			c, addr = pc, pc.LocalAddr().String()
+		}
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if isPath {
+		err = os.Chmod(la.addr, mode)
+	}
+
+	if err == nil {
+		f, ok := c.(filer)
+		if !ok {
+			err = os.NewError("cannot convert socket to filer") // can't happen
+		} else {
+			file, err = f.File()
+		}
+	}
+
+	if err != nil {
+		c.Close()
+		return nil, nil, "", err
+	}
+	return c, file, addr, nil
+}
+
+// Removes the socket at `p`, left behind by an earlier run perhaps. Anything
+// else at `p` is left alone, and is an error.
+func removeSocket(p string) os.Error {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return nil // nothing there, or listen will say what is wrong
+	}
+
+	if !fi.IsSocket() {
+		return os.NewError(p + " exists and is not a socket")
+	}
+	return os.Remove(p)
+}
+
 func (so *socket) open() {
 	if so.lfiles != nil {
 		return
@@ -74,25 +189,35 @@ func (so *socket) open() {
 
 	so.logger.Println("open")
 
-	laddr := so.lookupParam("socket/listen-stream")
-	li, err := net.Listen("tcp", laddr)
+	var c io.Closer
+	var file *os.File
+	var addr string
+	var addrs []string
+
+	las := so.lookupAddrs()
+	mode, err := so.lookupMode()
 	if err != nil {
 		goto error
 	}
 
-	f, ok := li.(filer)
-	if !ok {
-		err = os.NewError("cannot convert li to filer")
-		goto error // can't happen
-	}
-
-	var file *os.File
-	file, err = f.File()
-	if err != nil {
+	if len(las) == 0 {
+		err = os.NewError("no listen addresses")
 		goto error
 	}
 
-	so.lfiles = []*os.File{file}
+	for _, la := range las {
+		c, file, addr, err = listen(la, mode)
+		if err != nil {
+			goto error
+		}
+
+		so.conns = append(so.conns, c)
+		so.lfiles = append(so.lfiles, file)
+		if la.net == "unixgram" {
+			so.paths = append(so.paths, la.addr)
+		}
+		addrs = append(addrs, addr)
+	}
 
 	// Propagate our "want up" state to the sv. Note: this won't actualy run
 	// the service yet, since sv.lfiles is still nil.
@@ -100,7 +225,7 @@ func (so *socket) open() {
 
 	go so.setStatus("status", "up")
 	go so.delStatus("reason")
-	go so.setStatus("listen-addr", li.Addr().String())
+	go so.setStatus("listen-addr", strings.Join(addrs, " "))
 	go so.mon.poll(so.lfiles, so)
 
 	// Tell the service about the socket so the service can notify the socket
@@ -110,13 +235,30 @@ func (so *socket) open() {
 	return
 
 error:
+	so.closeAll()
+	so.lfiles = nil
 	so.wantUp = false // fatal error -- don't retry
 	so.logger.Println(err)
-	go so.setStatus("status", "down")
+	go so.setStatus("status", "failed")
 	go so.setStatus("reason", err.String())
 	go so.delStatus("listen-addr")
 }
 
+// Closes the sockets and the files for their fds. Closing a unix stream
+// listener removes its file; unix datagram socket files are removed here.
+func (so *socket) closeAll() {
+	for _, f := range so.lfiles {
+		f.Close()
+	}
+	for _, c := range so.conns {
+		c.Close()
+	}
+	for _, p := range so.paths {
+		os.Remove(p)
+	}
+	so.conns, so.paths = nil, nil
+}
+
 // We want to know if the service quits or dies, so we can start it up
 // again on socket activity.
 func (so *socket) exited() {
@@ -128,9 +270,7 @@ func (so *socket) close() {
 		return
 	}
 
-	for _, f := range so.lfiles {
-		f.Close()
-	}
+	so.closeAll()
 	so.lfiles = nil
 	so.sv.setActiveLFDs(nil)
 	so.logger.Println("closed, stopping service")
@@ -0,0 +1,74 @@
+package mon
+
+import (
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLookupAddrs(t *testing.T) {
+	dt := newDepsTest()
+	so := &socket{id: "a.socket", mon: dt.mon}
+	dt.set(defDir+"a.socket/socket/listen-stream", ":8000 /tmp/a.sock")
+	dt.set(defDir+"a.socket/socket/listen-datagram", ":53")
+
+	exp := []listenAddr{{"tcp", ":8000"}, {"unix", "/tmp/a.sock"}, {"udp", ":53"}}
+	assert.Equal(t, exp, so.lookupAddrs())
+}
+
+func TestLookupMode(t *testing.T) {
+	dt := newDepsTest()
+	so := &socket{id: "a.socket", mon: dt.mon}
+	mode, err := so.lookupMode()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(defSocketMode), mode)
+
+	dt.set(defDir+"a.socket/socket/socket-mode", "0600")
+	mode, err = so.lookupMode()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(0600), mode)
+
+	dt.set(defDir+"a.socket/socket/socket-mode", "8")
+	_, err = so.lookupMode()
+	assert.NotEqual(t, nil, err)
+}
+
+func TestListenUDP(t *testing.T) {
+	c, file, addr, err := listen(listenAddr{"udp", "127.0.0.1:0"}, defSocketMode)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, "", addr)
+	file.Close()
+	c.Close()
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	p := dir + "/a.sock"
+	c, file, _, err := listen(listenAddr{"unix", p}, 0600)
+	assert.Equal(t, nil, err)
+
+	fi, err := os.Stat(p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(0600), fi.Permission())
+
+	file.Close()
+	c.Close()
+}
+
+func TestListenUnixNotSocket(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+
+	p := dir + "/a.sock"
+	assert.Equal(t, nil, ioutil.WriteFile(p, []byte("keep"), 0644))
+
+	_, _, _, err := listen(listenAddr{"unix", p}, 0600)
+	assert.NotEqual(t, nil, err)
+
+	b, err := ioutil.ReadFile(p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "keep", string(b))
+}
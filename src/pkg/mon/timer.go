@@ -0,0 +1,180 @@
+package mon
+
+import (
+	"doozer/store"
+	"doozer/util"
+	"strconv"
+	"time"
+)
+
+// A timer unit activates a service on a schedule. Like a socket, it is held
+// by one node at a time through /lock, so each scheduled run happens once
+// in the whole cluster. The service defaults to the one with the timer's
+// name, and can be set with "timer/unit".
+type timerUnit struct {
+	id, name  string
+	sv        *service
+	logger    *util.Logger
+	mon       *monitor
+	wantUp    bool
+	lockCas   string
+	lockTaken bool
+	sched     schedule
+	next      int64 // ns; 0 when not scheduled
+}
+
+func newTimer(id, name string, mon *monitor) *timerUnit {
+	target := mon.lookupParam(id, "timer/unit")
+	if target == "" {
+		target = name + ".service"
+	}
+
+	sv := mon.increfService(target)
+	if sv == nil {
+		return nil
+	}
+
+	tu := &timerUnit{
+		id:     id,
+		name:   name,
+		sv:     sv,
+		mon:    mon,
+		logger: util.NewLogger("mon").With("unit", id),
+	}
+	tu.logger.Println("new")
+	return tu
+}
+
+func (tu *timerUnit) lookupParam(param string) string {
+	return tu.mon.lookupParam(tu.id, param)
+}
+
+func (tu *timerUnit) setStatus(param, val string) {
+	tu.mon.setStatus(tu.id, param, val)
+}
+
+func (tu *timerUnit) delStatus(param string) {
+	tu.mon.delStatus(tu.id, param)
+}
+
+// Returns the time this timer last fired anywhere in the cluster, or 0.
+func (tu *timerUnit) lastTrigger() int64 {
+	s := store.GetString(tu.mon.st, statusDir+tu.id+"/last-trigger")
+	t, err := strconv.Atoi64(s)
+	if err != nil {
+		return 0
+	}
+	return t
+}
+
+func (tu *timerUnit) open() {
+	if tu.sched != nil {
+		return
+	}
+
+	tu.logger.Println("open")
+
+	sched, err := lookupSchedule(tu.mon, tu.id)
+	if err != nil {
+		tu.wantUp = false // fatal error -- don't retry
+		tu.logger.Println(err)
+		go tu.setStatus("status", "failed")
+		go tu.setStatus("reason", err.String())
+		return
+	}
+
+	tu.sched = sched
+	go tu.setStatus("status", "up")
+	go tu.delStatus("reason")
+
+	// Carry on from where the last holder of the lock left off, so that a
+	// run it already made is not repeated here.
+	from := time.Nanoseconds()
+	if last := tu.lastTrigger(); last > from {
+		from = last
+	}
+	tu.schedule(from)
+}
+
+// Arranges for the first firing after `t`.
+func (tu *timerUnit) schedule(t int64) {
+	tu.next = tu.sched.next(t)
+	if tu.next < 0 {
+		tu.logger.Println("schedule never fires again")
+		tu.next = 0
+		go tu.delStatus("next")
+		return
+	}
+
+	go tu.setStatus("next", strconv.Itoa64(tu.next))
+	go tu.mon.timer(tu, tu.next-time.Nanoseconds())
+}
+
+func (tu *timerUnit) close() {
+	if tu.sched == nil {
+		return
+	}
+
+	tu.sched, tu.next = nil, 0
+	tu.logger.Println("closed, updating status")
+	go tu.setStatus("status", "down")
+	go tu.setStatus("reason", "requested")
+	go tu.delStatus("next")
+}
+
+func (tu *timerUnit) check() {
+	tu.logger.Println("checking up/down state")
+
+	if tu.wantUp {
+		if tu.lockCas == "" {
+			tu.close()
+			if !tu.lockTaken {
+				go tu.mon.tryLock(tu.id)
+			}
+		} else {
+			tu.open()
+		}
+	} else {
+		tu.close()
+		if tu.lockCas != "" {
+			go tu.mon.release(tu.id, tu.lockCas)
+		}
+	}
+}
+
+func (tu *timerUnit) start() {
+	tu.logger.Println("starting")
+	tu.wantUp = true
+	tu.check()
+}
+
+func (tu *timerUnit) stop() {
+	tu.logger.Println("stopping")
+	tu.wantUp = false
+	tu.check()
+}
+
+// Each call to schedule leaves a tick on its way. Ticks from a schedule that
+// has since been replaced arrive early, or after close, and are ignored.
+func (tu *timerUnit) tick() {
+	now := time.Nanoseconds()
+	if tu.next == 0 || now < tu.next {
+		return
+	}
+
+	tu.logger.Info("firing", "service", tu.sv.id)
+	go tu.setStatus("last-trigger", strconv.Itoa64(tu.next))
+	tu.sv.activate()
+	tu.schedule(now)
+}
+
+func (tu *timerUnit) dispatchLockEvent(ev store.Event) {
+	tu.logger.Println("got lock event", ev)
+	if ev.Body == tu.mon.self {
+		tu.lockCas, tu.lockTaken = ev.Cas, true
+		go tu.setStatus("node", tu.mon.self)
+	} else {
+		tu.lockCas, tu.lockTaken = "", ev.Body != ""
+	}
+	tu.check()
+}
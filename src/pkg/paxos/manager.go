@@ -1,7 +1,6 @@
 package paxos
 
 import (
-	"log"
 	"os"
 
 	"doozer/store"
@@ -25,7 +24,7 @@ type Manager struct {
 	seqns     chan uint64
 	fillUntil chan uint64
 	reqs      chan instReq
-	logger    *log.Logger
+	logger    *util.Logger
 	Self      string
 	alpha     int
 	outs      PutterTo
@@ -92,7 +91,7 @@ func (mg *Manager) fill(seqn uint64) {
 func (m *Manager) process() {
 	instances := make(map[uint64]instance)
 	var ver uint64
-	seqns := m.st.Watch("**")
+	seqns := m.st.MustWatch("**")
 	for {
 		select {
 		case ev := <-seqns:
@@ -146,24 +145,43 @@ func (m *Manager) proposeAt(seqn uint64, v string) {
 	it := m.getInstance(seqn)
 	if it != nil {
 		it.Propose(v)
-		m.logger.Printf("paxos propose -> %d %q", seqn, v)
+		m.logger.Debug("propose", "seqn", seqn, "value", v)
 	}
 }
 
-func (m *Manager) ProposeOnce(v string) store.Event {
-	seqn := <-m.seqns
+// Proposes `v` at the next seqn and returns the event applied there, which
+// may be a competing value. If `cancel` is closed first, the event's Err is
+// ErrCancelled.
+func (m *Manager) ProposeOnce(v string, cancel <-chan bool) (ev store.Event) {
+	var seqn uint64
+	select {
+	case seqn = <-m.seqns:
+	case <-cancel:
+		ev.Err = ErrCancelled
+		return
+	}
+
 	ch := m.st.Wait(seqn)
 	m.proposeAt(seqn, v)
 	m.fillUntil <- seqn
-	return <-ch
+
+	select {
+	case ev = <-ch:
+	case <-cancel:
+		ev = store.Event{Seqn: seqn, Err: ErrCancelled}
+	}
+	return
 }
 
-func (m *Manager) Propose(v string) (seqn uint64, cas string, err os.Error) {
+func (m *Manager) Propose(v string, cancel <-chan bool) (seqn uint64, cas string, err os.Error) {
 	var ev store.Event
 
 	// If a competing proposal succeeded in the same seqn, we should try again.
 	for v != ev.Mut {
-		ev = m.ProposeOnce(v)
+		ev = m.ProposeOnce(v, cancel)
+		if ev.Err == ErrCancelled {
+			return 0, "", ev.Err
+		}
 	}
 	return ev.Seqn, ev.Cas, ev.Err
 }
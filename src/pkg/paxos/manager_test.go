@@ -3,6 +3,7 @@ package paxos
 import (
 	"github.com/bmizerany/assert"
 	"doozer/store"
+	"os"
 	"testing"
 )
 
@@ -25,7 +26,7 @@ func selfRefNewManager(self string, alpha int) (*Manager, *store.Store) {
 func TestProposeAndLearn(t *testing.T) {
 	exp := "foo"
 	m, st := selfRefNewManager("a", 1)
-	ch := st.Watch("**")
+	ch := st.MustWatch("**")
 
 	seqn := <-m.seqns
 	ix := m.getInstance(seqn)
@@ -40,7 +41,7 @@ func TestProposeAndLearnMultiple(t *testing.T) {
 	exp := []string{"/foo", "/bar"}
 	seqnexp := []uint64{3, 4}
 	m, st := selfRefNewManager("a", 1)
-	ch := st.Watch("**")
+	ch := st.MustWatch("**")
 
 	ix := m.getInstance(<-m.seqns)
 	ix.Propose(exp[0])
@@ -73,7 +74,7 @@ func TestManagerFill(t *testing.T) {
 func TestNewInstanceBecauseOfMessage(t *testing.T) {
 	exp := "foo"
 	m, st := selfRefNewManager("a", 1)
-	ch := st.Watch("**")
+	ch := st.MustWatch("**")
 
 	msg := newVote(3, exp)
 	msg.SetSeqn(3)
@@ -87,7 +88,7 @@ func TestNewInstanceBecauseOfMessage(t *testing.T) {
 func TestNewInstanceBecauseOfMessageTriangulate(t *testing.T) {
 	exp := "bar"
 	m, st := selfRefNewManager("a", 1)
-	ch := st.Watch("**")
+	ch := st.MustWatch("**")
 
 	msg := newVote(3, exp)
 	msg.SetSeqn(3)
@@ -101,7 +102,7 @@ func TestNewInstanceBecauseOfMessageTriangulate(t *testing.T) {
 func TestUnusedSeqn(t *testing.T) {
 	exp := "bar"
 	m, st := selfRefNewManager("a", 1)
-	ch := st.Watch("**")
+	ch := st.MustWatch("**")
 
 	ix := m.getInstance(<-m.seqns)
 	ix.Propose(exp)
@@ -113,7 +114,7 @@ func TestUnusedSeqn(t *testing.T) {
 
 func TestIgnoreMalformedMsg(t *testing.T) {
 	m, st := selfRefNewManager("a", 1)
-	ch := st.Watch("**")
+	ch := st.MustWatch("**")
 
 	m.PutFrom(m.Self+"addr", resize(newVote(1, ""), -1))
 
@@ -130,7 +131,7 @@ func TestProposeAndStore(t *testing.T) {
 	mg, st := selfRefNewManager("a", 1)
 
 	ch := st.Wait(3)
-	mg.Propose(exp)
+	mg.Propose(exp, nil)
 	assert.Equal(t, exp, (<-ch).Mut)
 }
 
@@ -138,17 +139,39 @@ func BenchmarkPropose(b *testing.B) {
 	mg, _ := selfRefNewManager("a", 1)
 
 	for i := 0; i < b.N; i++ {
-		mg.Propose("foo")
+		mg.Propose("foo", nil)
 	}
 }
 
 func TestProposeBadMutation(t *testing.T) {
 	mg, _ := selfRefNewManager("a", 1)
 
-	_, _, err := mg.Propose("foo")
+	_, _, err := mg.Propose("foo", nil)
 	assert.Equal(t, store.ErrBadMutation, err)
 }
 
+// Sends messages nowhere, so that no value is ever chosen.
+type dropPutterTo struct{}
+
+func (dropPutterTo) PutTo(Msg, string) {}
+
+func TestProposeCancel(t *testing.T) {
+	st := store.New()
+	st.Ops <- store.Op{1, mustEncodeSet(membersDir+"a", "x")}
+	st.Ops <- store.Op{2, mustEncodeSet(slotDir+"0", "a")}
+	mg := NewManager("a", 1, st, dropPutterTo{})
+
+	cancel := make(chan bool)
+	done := make(chan os.Error)
+	go func() {
+		_, _, err := mg.Propose("foo", cancel)
+		done <- err
+	}()
+
+	close(cancel)
+	assert.Equal(t, ErrCancelled, <-done)
+}
+
 func mustEncodeSet(k, v string) string {
 	m, err := store.EncodeSet(k, v, store.Clobber)
 	if err != nil {
@@ -173,7 +196,7 @@ func TestReadFromStore(t *testing.T) {
 			ch <- e
 		}
 		close(ch)
-	}(st.Watch("**"))
+	}(st.MustWatch("**"))
 
 	m := NewManager(self, 1, st, p)
 
@@ -6,29 +6,54 @@ import (
 	"os"
 )
 
+var ErrCancelled = os.NewError("cancelled")
+
 type ReadFromer interface {
 	ReadFrom(b []byte) (n int, addr net.Addr, err os.Error)
 }
 
+// Propose blocks until `v` is chosen, or until `cancel` is closed, in which
+// case it returns ErrCancelled. The value may still be chosen later. A nil
+// `cancel` never closes.
 type Proposer interface {
-	Propose(v string) (seqn uint64, cas string, err os.Error)
+	Propose(v string, cancel <-chan bool) (seqn uint64, cas string, err os.Error)
 }
 
-func Set(p Proposer, path, body, cas string) (uint64, string, os.Error) {
+func Set(p Proposer, path, body, cas string, cancel <-chan bool) (uint64, string, os.Error) {
 	mut, err := store.EncodeSet(path, body, cas)
 	if err != nil {
 		return 0, "", err
 	}
 
-	return p.Propose(mut)
+	return p.Propose(mut, cancel)
 }
 
-func Del(p Proposer, path, cas string) os.Error {
+func Del(p Proposer, path, cas string, cancel <-chan bool) os.Error {
 	mut, err := store.EncodeDel(path, cas)
 	if err != nil {
 		return err
 	}
 
-	_, _, err = p.Propose(mut)
+	_, _, err = p.Propose(mut, cancel)
+	return err
+}
+
+func DelTree(p Proposer, path, cas string, cancel <-chan bool) os.Error {
+	mut, err := store.EncodeDelTree(path, cas)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = p.Propose(mut, cancel)
+	return err
+}
+
+func Move(p Proposer, from, to, cas string, cancel <-chan bool) os.Error {
+	mut, err := store.EncodeMove(from, to, cas)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = p.Propose(mut, cancel)
 	return err
 }
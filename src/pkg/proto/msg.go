@@ -18,6 +18,18 @@ type ReqDel struct {
 	Path, Cas string
 }
 
+type ReqMove struct {
+	From, To, Cas string
+}
+
+// See store.Filter.
+type ReqWatch struct {
+	Globs   []string
+	Exclude []string
+	Kinds   int
+	Depth   int
+}
+
 // e.g. join 4eec5bfb.38c24ce9 1.2.3.4:999
 type ReqJoin struct {
 	Who, Addr string
@@ -32,11 +44,20 @@ type ResGet struct {
 	Cas string
 }
 
+// The response to the older "join", which sends the whole snapshot at once.
+// It is kept so that nodes of different versions can join one another.
 type ResJoin struct {
 	Seqn     uint64
 	Snapshot string
 }
 
+// Sent as the first response to JOIN and BACKUP. It is followed by the
+// snapshot itself, in one or more chunks, the last of which is flagged Last.
+type ResSnapshot struct {
+	Seqn uint64
+	Len  int
+}
+
 type ResCheckin struct {
 	Exp int64
 	Cas string
@@ -47,6 +68,22 @@ type ResSett struct {
 	Cas string
 }
 
+// See store.Stat.
+type ResStat struct {
+	Cas      string
+	Created  uint64
+	Modified uint64
+	Version  int
+	Len      int
+	Children int
+}
+
+// See store.Version.
+type ResVersion struct {
+	Seqn      uint64
+	Body, Cas string
+}
+
 type ResWatch struct {
 	Path, Body, Cas string
 }
@@ -28,9 +28,20 @@ const (
 )
 
 var (
-	ErrClosed = os.NewError("response was closed")
+	ErrClosed     = os.NewError("response was closed")
+	ErrConnClosed = os.NewError("connection was closed")
+	ErrTimeout    = os.NewError("timeout")
+	ErrBusy       = os.NewError("busy")
 )
 
+// Errors that the server may send and that a client will want to recognize.
+// They arrive as a ResponseError with the same text, and are turned back
+// into these values.
+var wellKnown = map[string]os.Error{
+	ErrTimeout.String(): ErrTimeout,
+	ErrBusy.String():    ErrBusy,
+}
+
 // Response flags
 const (
 	Closed = 1 << iota
@@ -60,6 +71,10 @@ type Conn struct {
 
 	closed map[uint]bool
 
+	// Once set, the connection is unusable and every operation fails.
+	// Protected by bl.
+	err os.Error
+
 	rl, wl, bl sync.Mutex
 
 	RedirectAddr string
@@ -71,6 +86,16 @@ type request struct {
 	Data interface{}
 }
 
+// A request with a deadline. Timeout is in nanoseconds, relative to the time
+// the server reads the request, so that the clocks of client and server
+// need not agree.
+type timedRequest struct {
+	Verb    Line
+	Id      uint
+	Data    interface{}
+	Timeout int64
+}
+
 type response struct {
 	Id   uint
 	Flag uint
@@ -95,6 +120,30 @@ func (e *ProtoError) String() string {
 	return fmt.Sprintf("%s %d: %s", e.Op, e.Id, e.Error)
 }
 
+// A request that was read whole but is not well formed. Unlike other read
+// errors, it leaves the connection usable; the server should answer request
+// Id with Error and read on. Id is 0 if the request had no usable id.
+type BadRequestError struct {
+	Id    uint
+	Error os.Error
+}
+
+func (e *BadRequestError) String() string {
+	return fmt.Sprintf("bad request %d: %s", e.Id, e.Error)
+}
+
+// Makes a BadRequestError for `data`, taking the id from where a request
+// keeps it, if it can.
+func badRequest(data interface{}, err os.Error) *BadRequestError {
+	e := &BadRequestError{Error: err}
+	if parts, ok := data.([]interface{}); ok && len(parts) > 1 {
+		if Fit(parts[1], &e.Id) != nil {
+			e.Id = 0
+		}
+	}
+	return e
+}
+
 type ResponseError string
 
 func (e ResponseError) String() string {
@@ -111,6 +160,32 @@ func NewConn(rw io.ReadWriteCloser) *Conn {
 	}
 }
 
+// Marks the connection as broken by `err`. Every later operation will fail
+// without touching the underlying connection.
+func (c *Conn) poison(err os.Error) {
+	c.bl.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.bl.Unlock()
+}
+
+// Returns the error the connection was poisoned with, if any. Once this is
+// non-nil, the connection is of no further use.
+func (c *Conn) Err() os.Error {
+	c.bl.Lock()
+	defer c.bl.Unlock()
+	return c.err
+}
+
+// Poisons the connection and closes the underlying connection. Any requests
+// still waiting for responses will get ErrConnClosed, or whatever error the
+// connection had already failed with.
+func (c *Conn) Close() os.Error {
+	c.poison(ErrConnClosed)
+	return c.c.Close()
+}
+
 // Server functions
 
 func (c *Conn) CloseResponse(id uint) os.Error {
@@ -128,6 +203,10 @@ func (c *Conn) SendResponse(id, flag uint, data interface{}) os.Error {
 	c.wl.Lock()
 	defer c.wl.Unlock()
 
+	if err := c.Err(); err != nil {
+		return &ProtoError{id, SendRes, err}
+	}
+
 	if fullyClosed, wantClosed := c.closed[id]; wantClosed {
 		if fullyClosed || flag&(Closed|Last) == 0 {
 			return ErrClosed
@@ -137,56 +216,124 @@ func (c *Conn) SendResponse(id, flag uint, data interface{}) os.Error {
 
 	err := encode(c.c, response{id, flag, data})
 	if err != nil {
-		// TODO poison
+		c.poison(err)
 		return &ProtoError{id, SendRes, err}
 	}
 	return nil
 }
 
 func (c *Conn) ReadRequest() (uint, string, interface{}, os.Error) {
+	id, verb, data, _, err := c.ReadTimedRequest()
+	return id, verb, data, err
+}
+
+// Like ReadRequest, but also returns the request's timeout in nanoseconds,
+// or 0 if it has none.
+//
+// A request that is badly shaped, or has a negative timeout, is reported as
+// a *BadRequestError. Any other error means the connection is done.
+func (c *Conn) ReadTimedRequest() (uint, string, interface{}, int64, os.Error) {
 	c.rl.Lock()
 	defer c.rl.Unlock()
 
+	if err := c.Err(); err != nil {
+		return 0, "", nil, 0, &ProtoError{0, ReadReq, err}
+	}
+
 	data, err := decode(c.r)
 	if err != nil {
-		// TODO poison
+		c.poison(err)
 		if err == os.EOF {
-			return 0, "", nil, err
+			return 0, "", nil, 0, err
 		} else {
-			return 0, "", nil, &ProtoError{0, ReadReq, err}
+			return 0, "", nil, 0, &ProtoError{0, ReadReq, err}
 		}
 	}
 
-	var req request
-	err = Fit(data, &req)
+	var req timedRequest
+	if parts, ok := data.([]interface{}); ok && len(parts) == 4 {
+		err = Fit(data, &req)
+	} else {
+		var r request
+		err = Fit(data, &r)
+		req = timedRequest{r.Verb, r.Id, r.Data, 0}
+	}
 	if err != nil {
-		return 0, "", nil, &ProtoError{0, ReadReq, err}
+		return 0, "", nil, 0, badRequest(data, err)
+	}
+
+	if req.Timeout < 0 {
+		return 0, "", nil, 0, &BadRequestError{req.Id, os.ERANGE}
 	}
 
-	logger.Println("got data", req.Data)
-	return req.Id, string(req.Verb), req.Data, nil
+	logger.Debug("got request", "data", req.Data)
+	return req.Id, string(req.Verb), req.Data, req.Timeout, nil
 }
 
 // Client functions
 
 func (c *Conn) SendRequest(verb string, data interface{}) (Response, os.Error) {
-	ch := make(chan interface{})
+	_, r, err := c.StartRequest(verb, data)
+	return r, err
+}
+
+// Like SendRequest, but also returns the id of the request, which can be
+// passed to Cancel.
+func (c *Conn) StartRequest(verb string, data interface{}) (uint, Response, os.Error) {
+	return c.StartTimedRequest(verb, data, 0)
+}
+
+// Like StartRequest, but asks the server to give up on the request after
+// `timeout` nanoseconds, if it is nonzero. The server then sends ErrTimeout
+// as the last response.
+func (c *Conn) StartTimedRequest(verb string, data interface{}, timeout int64) (uint, Response, os.Error) {
+	// One slot, so that the final error can be left for a caller who is
+	// not reading just then, or never will.
+	ch := make(chan interface{}, 1)
 
 	c.bl.Lock()
+	if c.err != nil {
+		err := c.err
+		c.bl.Unlock()
+		return 0, nil, &ProtoError{0, SendReq, err}
+	}
 	c.id++
 	id := c.id
 	c.cb[id] = ch
 	c.bl.Unlock()
 
+	var req interface{}
+	if timeout > 0 {
+		req = timedRequest{Line(verb), id, data, timeout}
+	} else {
+		req = request{Line(verb), id, data}
+	}
+
 	c.wl.Lock()
-	err := encode(c.c, request{Line(verb), id, data})
+	err := encode(c.c, req)
 	c.wl.Unlock()
 	if err != nil {
-		// TODO poison
-		return nil, &ProtoError{id, SendReq, err}
+		c.poison(err)
+		c.bl.Lock()
+		c.cb[id] = nil, false
+		c.bl.Unlock()
+		return 0, nil, &ProtoError{id, SendReq, err}
 	}
 
-	return Response(ch), nil
+	return id, Response(ch), nil
+}
+
+// Asks the server to stop sending responses to request `id`. The request's
+// Response will be closed once the server has done so. Until then, the
+// caller must keep reading the Response, as it may still receive data.
+func (c *Conn) Cancel(id uint) os.Error {
+	r, err := c.SendRequest("CLOSE", id)
+	if err != nil {
+		return err
+	}
+
+	var res string
+	return r.Get(&res)
 }
 
 func (c *Conn) fitResponse(x interface{}) (res response) {
@@ -194,6 +341,9 @@ func (c *Conn) fitResponse(x interface{}) (res response) {
 	if err != nil {
 		res.Data = err
 	}
+	if e, ok := res.Data.(ResponseError); ok && wellKnown[string(e)] != nil {
+		res.Data = wellKnown[string(e)]
+	}
 	if r, ok := res.Data.(Redirect); ok {
 		c.RedirectAddr = string(r)
 		logger.Println("redirect to", c.RedirectAddr)
@@ -206,8 +356,10 @@ func (c *Conn) ReadResponses() {
 	c.rl.Lock()
 	defer c.rl.Unlock()
 
+	var err os.Error
 	for {
-		data, err := decode(c.r)
+		var data interface{}
+		data, err = decode(c.r)
 		if err != nil {
 			break
 		}
@@ -233,8 +385,23 @@ func (c *Conn) ReadResponses() {
 		}
 	}
 
-	// TODO poison
-	// TODO send errors to all waiting requests
+	c.poison(err)
+
+	// Nothing more will arrive, so fail every request still waiting.
+	c.bl.Lock()
+	cb := c.cb
+	c.cb = make(map[uint]chan interface{})
+	err = c.err
+	c.bl.Unlock()
+
+	for _, ch := range cb {
+		select {
+		case ch <- &ProtoError{0, ReadRes, err}:
+		default:
+			// Its slot is full and nobody is reading; closing will do.
+		}
+		close(ch)
+	}
 }
 
 type Response <-chan interface{}
@@ -245,7 +412,7 @@ func (r Response) Get(slot interface{}) os.Error {
 		return e
 	}
 
-	logger.Println("got data", data)
+	logger.Debug("got response", "data", data)
 	err := Fit(data, slot)
 	if err != nil {
 		return &ProtoError{0, ReadRes, err}
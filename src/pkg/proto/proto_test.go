@@ -196,3 +196,207 @@ func TestRedirect(t *testing.T) {
 	assert.Equal(t, os.EAGAIN, res.Data)
 	assert.Equal(t, addr, c.RedirectAddr)
 }
+
+type pipeConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (p pipeConn) Close() os.Error {
+	return nil
+}
+
+func TestSendRequestErrorForgetsCallback(t *testing.T) {
+	w := &ErroneousWriter{Writer: new(bytes.Buffer)}
+	c := NewConn(pipeConn{new(bytes.Buffer), w})
+	_, err := c.SendRequest("NOOP", nil)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 0, len(c.cb))
+}
+
+func TestCancel(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	cl := NewConn(pipeConn{cr, cw})
+	sv := NewConn(pipeConn{sr, sw})
+	go cl.ReadResponses()
+
+	id, r, err := cl.StartRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+
+	rid, verb, _, err := sv.ReadRequest()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id, rid)
+	assert.Equal(t, "WATCH", verb)
+
+	assert.Equal(t, nil, sv.SendResponse(rid, 0, "a"))
+	assert.Equal(t, []byte("a"), <-r)
+
+	done := make(chan os.Error)
+	go func() {
+		done <- cl.Cancel(id)
+	}()
+
+	crid, verb, data, err := sv.ReadRequest()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "CLOSE", verb)
+	assert.Equal(t, int64(id), data)
+
+	// The stream may still be sent to until it is closed.
+	assert.Equal(t, nil, sv.SendResponse(rid, 0, "b"))
+	assert.Equal(t, nil, sv.CloseResponse(rid))
+	assert.Equal(t, nil, sv.SendResponse(crid, Last, Line("OK")))
+
+	assert.Equal(t, []byte("b"), <-r)
+	<-r
+	assert.T(t, closed(r))
+	assert.Equal(t, nil, <-done)
+
+	cl.bl.Lock()
+	assert.Equal(t, 0, len(cl.cb))
+	cl.bl.Unlock()
+}
+
+func TestReadResponsesEOFFailsWaiters(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	cl := NewConn(pipeConn{cr, cw})
+	sv := NewConn(pipeConn{sr, sw})
+	done := make(chan bool)
+	go func() {
+		cl.ReadResponses()
+		done <- true
+	}()
+
+	_, r, err := cl.StartRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+	_, _, _, err = sv.ReadRequest()
+	assert.Equal(t, nil, err)
+
+	sw.Close()
+
+	_, ok := (<-r).(*ProtoError)
+	assert.T(t, ok)
+	<-r
+	assert.T(t, closed(r))
+	<-done
+
+	_, err = cl.SendRequest("NOOP", nil)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestReadResponsesEOFIdleWaiters(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	cl := NewConn(pipeConn{cr, cw})
+	sv := NewConn(pipeConn{sr, sw})
+	done := make(chan bool)
+	go func() {
+		cl.ReadResponses()
+		done <- true
+	}()
+
+	_, r, err := cl.StartRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+	rid, _, _, err := sv.ReadRequest()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, sv.SendResponse(rid, 0, "a"))
+	_, r2, err := cl.StartRequest("WATCH", "/y")
+	assert.Equal(t, nil, err)
+	_, _, _, err = sv.ReadRequest()
+	assert.Equal(t, nil, err)
+
+	// Nobody reads r or r2, yet the connection still comes down.
+	sw.Close()
+	<-done
+
+	assert.Equal(t, []byte("a"), <-r)
+	<-r
+	assert.T(t, closed(r))
+	_, ok := (<-r2).(*ProtoError)
+	assert.T(t, ok)
+}
+
+func TestSendResponsePoisoned(t *testing.T) {
+	w := &ErroneousWriter{Writer: new(bytes.Buffer)}
+	c := NewConn(pipeConn{new(bytes.Buffer), w})
+
+	err := c.SendResponse(1, Last, "a")
+	assert.NotEqual(t, nil, err)
+
+	// The writer would now succeed, but the connection stays broken.
+	w.which = 100
+	err = c.SendResponse(2, Last, "a")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 0, w.Writer.(*bytes.Buffer).Len())
+}
+
+func TestTimedRequest(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	cl := NewConn(pipeConn{cr, cw})
+	sv := NewConn(pipeConn{sr, sw})
+	go cl.ReadResponses()
+
+	id, r, err := cl.StartTimedRequest("SET", "x", 5e8)
+	assert.Equal(t, nil, err)
+
+	rid, verb, data, timeout, err := sv.ReadTimedRequest()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id, rid)
+	assert.Equal(t, "SET", verb)
+	assert.Equal(t, []byte("x"), data)
+	assert.Equal(t, int64(5e8), timeout)
+
+	assert.Equal(t, nil, sv.SendResponse(rid, Last, ErrTimeout))
+	assert.Equal(t, ErrTimeout, r.Get(nil))
+}
+
+func TestUntimedRequest(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	cl := NewConn(pipeConn{cr, cw})
+	sv := NewConn(pipeConn{sr, sw})
+	go cl.ReadResponses()
+
+	_, _, err := cl.StartRequest("NOOP", nil)
+	assert.Equal(t, nil, err)
+
+	_, _, _, timeout, err := sv.ReadTimedRequest()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), timeout)
+}
+
+func TestBadRequestLeavesConnUsable(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	cl := NewConn(pipeConn{cr, cw})
+	sv := NewConn(pipeConn{sr, sw})
+	go cl.ReadResponses()
+
+	go func() {
+		encode(cw, []interface{}{Line("SET"), uint(7), "x", int64(-1)})
+		encode(cw, []interface{}{Line("SET"), uint(8)})
+		encode(cw, Line("NOOP"))
+	}()
+
+	_, _, _, _, err := sv.ReadTimedRequest()
+	assert.Equal(t, &BadRequestError{7, os.ERANGE}, err)
+
+	_, _, _, _, err = sv.ReadTimedRequest()
+	e, ok := err.(*BadRequestError)
+	assert.Tf(t, ok, "got %T: %v", err, err)
+	assert.Equal(t, uint(8), e.Id)
+
+	_, _, _, _, err = sv.ReadTimedRequest()
+	e, ok = err.(*BadRequestError)
+	assert.Tf(t, ok, "got %T: %v", err, err)
+	assert.Equal(t, uint(0), e.Id)
+
+	id, _, err := cl.StartRequest("NOOP", nil)
+	assert.Equal(t, nil, err)
+	rid, verb, _, err := sv.ReadRequest()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id, rid)
+	assert.Equal(t, "NOOP", verb)
+}
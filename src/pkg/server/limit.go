@@ -0,0 +1,113 @@
+package server
+
+import (
+	"doozer/proto"
+	"net"
+	"time"
+)
+
+// Limits on what clients may do. A request that would go over a limit gets
+// proto.ErrBusy, and the client is expected to back off and try again. A
+// zero in any field means no limit.
+type Limits struct {
+	Conns     int // open client connections
+	Requests  int // requests in progress on one connection
+	Watches   int // watches open on one connection
+	WriteRate int // writes per second, over the whole server
+}
+
+// Returns false iff another connection would go over the limit. If it
+// returns true, the caller must call dropConn when the connection ends.
+func (sv *Server) addConn() bool {
+	sv.lk.Lock()
+	defer sv.lk.Unlock()
+
+	if sv.Limits.Conns > 0 && sv.nconns >= sv.Limits.Conns {
+		return false
+	}
+	sv.nconns++
+	return true
+}
+
+func (sv *Server) dropConn() {
+	sv.lk.Lock()
+	defer sv.lk.Unlock()
+	sv.nconns--
+}
+
+// Answers the first request on `rw` with proto.ErrBusy, then hangs up.
+func refuse(rw net.Conn) {
+	pr := proto.NewConn(rw)
+	rid, _, _, err := pr.ReadRequest()
+	if err == nil {
+		pr.SendResponse(rid, proto.Last, proto.ErrBusy)
+	}
+	pr.Close()
+}
+
+// Returns true iff a write may go ahead now. Writes are metered by a token
+// bucket that holds up to one second's worth of writes.
+func (sv *Server) allowWrite() bool {
+	if sv.Limits.WriteRate <= 0 {
+		return true
+	}
+
+	sv.lk.Lock()
+	defer sv.lk.Unlock()
+
+	rate := float64(sv.Limits.WriteRate)
+	now := time.Nanoseconds()
+	if sv.last == 0 {
+		sv.tokens = rate
+	} else {
+		sv.tokens += rate * float64(now-sv.last) / 1e9
+		if sv.tokens > rate {
+			sv.tokens = rate
+		}
+	}
+	sv.last = now
+
+	if sv.tokens < 1 {
+		return false
+	}
+	sv.tokens--
+	return true
+}
+
+// Returns false iff another request on `c` would go over the limit. If it
+// returns true, the caller must call c.endRequest when the request is done.
+func (c *conn) startRequest() bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.s.Limits.Requests > 0 && c.nreqs >= c.s.Limits.Requests {
+		return false
+	}
+	c.nreqs++
+	return true
+}
+
+func (c *conn) endRequest() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.nreqs--
+}
+
+// Like startRequest, for watches. The caller must call c.endWatch when the
+// watch is done.
+func (c *conn) startWatch() bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.s.Limits.Watches > 0 && c.nwatches >= c.s.Limits.Watches {
+		return false
+	}
+	c.nwatches++
+	return true
+}
+
+func (c *conn) endWatch() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.nwatches--
+}
@@ -11,13 +11,16 @@ import (
 	"rand"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-import "log"
-
 const packetSize = 3000
 
+// Snapshots are sent to joining members in pieces of at most this many bytes.
+const snapChunkSize = 64 * 1024
+
 const lease = 3e9 // ns == 3s
 
 var (
@@ -34,21 +37,47 @@ type conn struct {
 	c   net.Conn
 	s   *Server
 	cal bool
+
+	// Closed to cancel the request with that id. Protected by lk.
+	cancels map[uint]chan bool
+
+	// Requests and watches in progress. Protected by lk.
+	nreqs    int
+	nwatches int
+
+	lk sync.Mutex
+}
+
+func newConn(rw net.Conn, s *Server, cal bool) *conn {
+	return &conn{
+		Conn:    proto.NewConn(rw),
+		c:       rw,
+		s:       s,
+		cal:     cal,
+		cancels: make(map[uint]chan bool),
+	}
 }
 
 type Manager interface {
 	paxos.Proposer
-	ProposeOnce(v string) store.Event
+	ProposeOnce(v string, cancel <-chan bool) store.Event
 	PutFrom(string, paxos.Msg)
 	Alpha() int
 }
 
 type Server struct {
-	Conn net.PacketConn
-	Addr string
-	St   *store.Store
-	Mg   Manager
-	Self string
+	Conn   net.PacketConn
+	Addr   string
+	St     *store.Store
+	Mg     Manager
+	Self   string
+	Limits Limits
+
+	// Connection count and write rate bucket. Protected by lk.
+	nconns int
+	tokens float64
+	last   int64
+	lk     sync.Mutex
 }
 
 func (sv *Server) ServeUdp(outs chan paxos.Packet) {
@@ -59,19 +88,24 @@ func (sv *Server) ServeUdp(outs chan paxos.Packet) {
 	}
 }
 
-var clg = util.NewLogger("cal")
+var logger = util.NewLogger("server")
 
 func (s *Server) Serve(l net.Listener, cal chan int) os.Error {
 	for {
 		rw, err := l.Accept()
 		if err != nil {
-			log.Printf("%#v", err)
+			logger.Error("accept", "err", err)
 			if e, ok := err.(*net.OpError); ok && e.Error == os.EINVAL {
 				return nil
 			}
 			return err
 		}
-		c := &conn{proto.NewConn(rw), rw, s, closed(cal)}
+		if !s.addConn() {
+			go refuse(rw)
+			continue
+		}
+
+		c := newConn(rw, s, closed(cal))
 		go c.serve()
 	}
 
@@ -89,7 +123,7 @@ func (sv *Server) cals() []string {
 // Repeatedly propose nop values until a successful read from `done`.
 func (sv *Server) AdvanceUntil(done chan int) {
 	for _, ok := <-done; !ok; _, ok = <-done {
-		sv.Mg.Propose(store.Nop)
+		sv.Mg.Propose(store.Nop, nil)
 	}
 }
 
@@ -114,23 +148,63 @@ func get(c *conn, _ uint, data interface{}) interface{} {
 	return proto.ResGet{v, cas}
 }
 
+func stat(c *conn, _ uint, data interface{}) interface{} {
+	path := data.(string)
+	s, cas := c.s.St.Stat(path)
+	return proto.ResStat{cas, s.Created, s.Modified, s.Version, s.Len, s.Children}
+}
+
+func history(c *conn, _ uint, data interface{}) interface{} {
+	path := data.(string)
+	vs := c.s.St.History(path)
+	res := make([]interface{}, len(vs))
+	for i, v := range vs {
+		res[i] = proto.ResVersion{v.Seqn, v.Body, v.Cas}
+	}
+	return res
+}
+
 func sget(c *conn, _ uint, data interface{}) interface{} {
 	r := data.(*proto.ReqGet)
-	return store.GetString(c.s.St.SyncPath(r.Path), r.Path)
+	g, err := c.s.St.SyncPath(r.Path)
+	if err != nil {
+		return err
+	}
+	return store.GetString(g, r.Path)
 }
 
-func set(c *conn, _ uint, data interface{}) interface{} {
+func set(c *conn, id uint, data interface{}) interface{} {
 	r := data.(*proto.ReqSet)
-	_, cas, err := paxos.Set(c.s.Mg, r.Path, r.Body, r.Cas)
+	_, cas, err := paxos.Set(c.s.Mg, r.Path, r.Body, r.Cas, c.cancelled(id))
 	if err != nil {
 		return err
 	}
 	return cas
 }
 
-func del(c *conn, _ uint, data interface{}) interface{} {
+func del(c *conn, id uint, data interface{}) interface{} {
 	r := data.(*proto.ReqDel)
-	err := paxos.Del(c.s.Mg, r.Path, r.Cas)
+	err := paxos.Del(c.s.Mg, r.Path, r.Cas, c.cancelled(id))
+	if err != nil {
+		return err
+	}
+
+	return Ok
+}
+
+func delTree(c *conn, id uint, data interface{}) interface{} {
+	r := data.(*proto.ReqDel)
+	err := paxos.DelTree(c.s.Mg, r.Path, r.Cas, c.cancelled(id))
+	if err != nil {
+		return err
+	}
+
+	return Ok
+}
+
+func move(c *conn, id uint, data interface{}) interface{} {
+	r := data.(*proto.ReqMove)
+	err := paxos.Move(c.s.Mg, r.From, r.To, r.Cas, c.cancelled(id))
 	if err != nil {
 		return err
 	}
@@ -138,15 +212,18 @@ func del(c *conn, _ uint, data interface{}) interface{} {
 	return Ok
 }
 
-func noop(c *conn, _ uint, data interface{}) interface{} {
-	c.s.Mg.ProposeOnce(store.Nop)
+func noop(c *conn, id uint, data interface{}) interface{} {
+	ev := c.s.Mg.ProposeOnce(store.Nop, c.cancelled(id))
+	if ev.Err == paxos.ErrCancelled {
+		return ev.Err
+	}
 	return Ok
 }
 
-func join(c *conn, _ uint, data interface{}) interface{} {
-	r := data.(*proto.ReqJoin)
+// Adds the member and waits until its addition takes effect.
+func addMember(c *conn, id uint, r *proto.ReqJoin) os.Error {
 	key := "/doozer/members/" + r.Who
-	seqn, _, err := paxos.Set(c.s.Mg, key, r.Addr, store.Missing)
+	seqn, _, err := paxos.Set(c.s.Mg, key, r.Addr, store.Missing, c.cancelled(id))
 	if err != nil {
 		return err
 	}
@@ -155,24 +232,67 @@ func join(c *conn, _ uint, data interface{}) interface{} {
 	go c.s.AdvanceUntil(done)
 	c.s.St.Sync(seqn + uint64(c.s.Mg.Alpha()))
 	close(done)
-	seqn, snap := c.s.St.Snapshot()
+	return nil
+}
+
+func join(c *conn, id uint, data interface{}) interface{} {
+	err := addMember(c, id, data.(*proto.ReqJoin))
+	if err != nil {
+		return err
+	}
+	return sendSnapshot(c, id)
+}
+
+// The older join, which sends the snapshot in a single response, and in the
+// legacy form that nodes from before JOIN can read.
+func oldJoin(c *conn, id uint, data interface{}) interface{} {
+	err := addMember(c, id, data.(*proto.ReqJoin))
+	if err != nil {
+		return err
+	}
+
+	seqn, snap := c.s.St.LegacySnapshot()
 	return proto.ResJoin{seqn, snap}
 }
 
-func sett(c *conn, _ uint, data interface{}) interface{} {
+func backup(c *conn, id uint, data interface{}) interface{} {
+	return sendSnapshot(c, id)
+}
+
+// Sends the current snapshot of the store as a ResSnapshot header followed by
+// chunks of at most snapChunkSize bytes. The final chunk is left for the
+// caller to send as the Last response.
+func sendSnapshot(c *conn, id uint) interface{} {
+	seqn, snap := c.s.St.Snapshot()
+	err := c.SendResponse(id, 0, proto.ResSnapshot{seqn, len(snap)})
+	if err != nil {
+		return responded
+	}
+
+	for len(snap) > snapChunkSize {
+		err = c.SendResponse(id, 0, snap[0:snapChunkSize])
+		if err != nil {
+			return responded
+		}
+		snap = snap[snapChunkSize:]
+	}
+	return snap
+}
+
+func sett(c *conn, id uint, data interface{}) interface{} {
 	r := data.(*proto.ReqSett)
 	t := time.Nanoseconds() + r.Interval
-	_, cas, err := paxos.Set(c.s.Mg, r.Path, strconv.Itoa64(t), r.Cas)
+	_, cas, err := paxos.Set(c.s.Mg, r.Path, strconv.Itoa64(t), r.Cas, c.cancelled(id))
 	if err != nil {
 		return err
 	}
 	return proto.ResSett{t, cas}
 }
 
-func checkin(c *conn, _ uint, data interface{}) interface{} {
+func checkin(c *conn, id uint, data interface{}) interface{} {
 	r := data.(*proto.ReqCheckin)
 	t := time.Nanoseconds() + lease
-	_, cas, err := paxos.Set(c.s.Mg, "/session/"+r.Sid, strconv.Itoa64(t), r.Cas)
+	_, cas, err := paxos.Set(c.s.Mg, "/session/"+r.Sid, strconv.Itoa64(t), r.Cas, c.cancelled(id))
 	if err != nil {
 		return err
 	}
@@ -180,6 +300,7 @@ func checkin(c *conn, _ uint, data interface{}) interface{} {
 }
 
 func closeOp(c *conn, _ uint, data interface{}) interface{} {
+	c.cancel(data.(uint))
 	err := c.CloseResponse(data.(uint))
 	if err != nil {
 		return err
@@ -187,26 +308,84 @@ func closeOp(c *conn, _ uint, data interface{}) interface{} {
 	return Ok
 }
 
+// The argument is either a single glob or a ReqWatch.
 func watch(c *conn, id uint, data interface{}) interface{} {
-	glob := data.(string)
-	// TODO check glob pattern for errors
-	ch := c.s.St.Watch(glob)
+	var f store.Filter
+	switch t := data.(type) {
+	case []byte:
+		f.Globs = []string{string(t)}
+	default:
+		var r *proto.ReqWatch
+		err := proto.Fit(data, &r)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return os.EINVAL
+		}
+		f = store.Filter{r.Globs, r.Exclude, r.Kinds, r.Depth}
+	}
+
+	if !c.startWatch() {
+		return proto.ErrBusy
+	}
+	defer c.endWatch()
+
+	ch, err := c.s.St.WatchFilter(f)
+	if err != nil {
+		return err
+	}
+
+	cancelled := c.cancelled(id)
+
 	// TODO buffer (and possibly discard) events
-	for ev := range ch {
+	for {
+		var ev store.Event
+		select {
+		case ev = <-ch:
+		case <-cancelled:
+			close(ch)
+			return responded
+		}
+
+		if closed(ch) {
+			break
+		}
+
 		var r proto.ResWatch
 		r.Path = ev.Path
 		r.Body = ev.Body
 		r.Cas = ev.Cas
 		err := c.SendResponse(id, 0, r)
-		if err == proto.ErrClosed {
+		if err != nil {
+			// Either the client closed the response or the connection is
+			// gone. In both cases, nobody wants any more events.
 			close(ch)
-			err = nil
+			break
 		}
+	}
+	return responded
+}
+
+// Walks a snapshot, so that every file sent is as of the same seqn, however
+// long the client takes to read them.
+func walk(c *conn, id uint, data interface{}) interface{} {
+	ch, err := store.Walk(c.s.St.Snap(), data.(string))
+	if err != nil {
+		return err
+	}
+
+	for ev := range ch {
+		err := c.SendResponse(id, 0, proto.ResWatch{ev.Path, ev.Body, ev.Cas})
 		if err != nil {
-			// TODO log error
-			break
+			// Let the walk finish so its goroutine can exit.
+			for _ = range ch {
+			}
+			return responded
 		}
 	}
+
+	c.SendResponse(id, proto.Closed, nil)
 	return responded
 }
 
@@ -221,26 +400,112 @@ type op struct {
 	f handler
 
 	redirect bool
+
+	// Cluster members need these to stay in the cluster, so they are not
+	// held to Limits.WriteRate. See metered.
+	unmetered bool
+}
+
+// Files below this directory are written by the cluster members themselves,
+// as when a node claims a slot or reports how far it has applied.
+const clusterDir = "/doozer/"
+
+// Returns true iff a request for `o` with data `data` counts against
+// Limits.WriteRate.
+func (o op) metered(data interface{}) bool {
+	if !o.redirect || o.unmetered {
+		return false
+	}
+
+	r, ok := data.(*proto.ReqSet)
+	return !ok || !strings.HasPrefix(r.Path, clusterDir)
 }
 
 var ops = map[string]op{
 	// new stuff, see doc/proto.md
-	"CLOSE": {p: new(uint), f: closeOp},
-	"DEL":   {p: new(*proto.ReqDel), f: del, redirect: true},
-	"NOOP":  {p: new(interface{}), f: noop, redirect: true},
-	"SET":   {p: new(*proto.ReqSet), f: set, redirect: true},
-	"SETT":  {p: new(*proto.ReqSett), f: sett, redirect: true},
-	"WATCH": {p: new(string), f: watch},
+	"BACKUP":  {p: new(interface{}), f: backup},
+	"CLOSE":   {p: new(uint), f: closeOp},
+	"DEL":     {p: new(*proto.ReqDel), f: del, redirect: true},
+	"DELTREE": {p: new(*proto.ReqDel), f: delTree, redirect: true},
+	"HISTORY": {p: new(string), f: history},
+	"JOIN":    {p: new(*proto.ReqJoin), f: join, redirect: true, unmetered: true},
+	"MOVE":    {p: new(*proto.ReqMove), f: move, redirect: true},
+	"NOOP":    {p: new(interface{}), f: noop, redirect: true, unmetered: true},
+	"SET":     {p: new(*proto.ReqSet), f: set, redirect: true},
+	"SETT":    {p: new(*proto.ReqSett), f: sett, redirect: true},
+	"STAT":    {p: new(string), f: stat},
+	"WALK":    {p: new(string), f: walk},
+	"WATCH":   {p: new(interface{}), f: watch},
 
 	// former stuff
 	"get":     {p: new(*proto.ReqGet), f: get},
 	"sget":    {p: new(*proto.ReqGet), f: sget},
-	"join":    {p: new(*proto.ReqJoin), f: join, redirect: true},
-	"checkin": {p: new(*proto.ReqCheckin), f: checkin, redirect: true},
+	"join":    {p: new(*proto.ReqJoin), f: oldJoin, redirect: true, unmetered: true},
+	"checkin": {p: new(*proto.ReqCheckin), f: checkin, redirect: true, unmetered: true},
+}
+
+// Returns a channel that will be closed if request `id` is cancelled, by
+// CLOSE or because the connection has gone away.
+func (c *conn) cancelled(id uint) <-chan bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if ch, ok := c.cancels[id]; ok {
+		return ch
+	}
+
+	// Already cancelled.
+	ch := make(chan bool)
+	close(ch)
+	return ch
+}
+
+func (c *conn) cancel(id uint) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if ch, ok := c.cancels[id]; ok {
+		close(ch)
+		c.cancels[id] = nil, false
+	}
+}
+
+func (c *conn) cancelAll() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	for id, ch := range c.cancels {
+		close(ch)
+		c.cancels[id] = nil, false
+	}
 }
 
-func (c *conn) handle(rid uint, f handler, data interface{}) {
-	res := f(c, rid, data)
+// Calls `f` and sends its result. If `timeout` is nonzero and `f` takes
+// longer than that many nanoseconds, the request is cancelled and the client
+// gets proto.ErrTimeout instead. A cancelled write stops being proposed, but
+// one already proposed may still be applied after the client has been told
+// it timed out.
+func (c *conn) handle(rid uint, f handler, data interface{}, timeout int64) {
+	defer c.endRequest()
+
+	var res interface{}
+	if timeout > 0 {
+		ch := make(chan interface{}, 1)
+		go func() {
+			ch <- f(c, rid, data)
+		}()
+
+		select {
+		case res = <-ch:
+		case <-time.After(timeout):
+			c.cancel(rid)
+			res = proto.ErrTimeout
+		}
+	} else {
+		res = f(c, rid, data)
+	}
+
+	c.lk.Lock()
+	c.cancels[rid] = nil, false
+	c.lk.Unlock()
+
 	if res == responded {
 		return
 	}
@@ -248,24 +513,39 @@ func (c *conn) handle(rid uint, f handler, data interface{}) {
 	c.SendResponse(rid, proto.Last, res)
 }
 
+// Reads and dispatches requests until the connection fails. Then cancels
+// every request still in progress and closes the connection, so that
+// handlers waiting to send responses give up right away.
 func (c *conn) serve() {
-	logger := util.NewLogger("%v", c.c.RemoteAddr())
-	logger.Println("accepted connection")
+	lg := logger.With("addr", c.c.RemoteAddr())
+	lg.Info("accepted connection")
+
+	defer func() {
+		c.cancelAll()
+		c.Close()
+		c.s.dropConn()
+	}()
+
 	for {
-		rid, verb, data, err := c.ReadRequest()
+		rid, verb, data, timeout, err := c.ReadTimedRequest()
+		if e, ok := err.(*proto.BadRequestError); ok {
+			lg.Warn("bad request", "req", e.Id, "err", e.Error)
+			c.SendResponse(e.Id, proto.Last, e.Error)
+			continue
+		}
 		if err != nil {
 			if err == os.EOF {
-				logger.Println("connection closed by peer")
+				lg.Info("connection closed by peer")
 			} else {
-				logger.Println(err)
+				lg.Warn("read request", "err", err)
 			}
 			return
 		}
 
-		rlogger := util.NewLogger("%v - req [%d]", c.c.RemoteAddr(), rid)
+		rlg := lg.With("req", rid)
 
 		if o, ok := ops[verb]; ok {
-			rlogger.Printf("%s %v", verb, data)
+			rlg.Debug(verb, "data", data)
 
 			err := proto.Fit(data, o.p)
 			if err != nil {
@@ -278,11 +558,26 @@ func (c *conn) serve() {
 				continue
 			}
 
-			go c.handle(rid, o.f, indirect(o.p))
+			if !c.startRequest() {
+				c.SendResponse(rid, proto.Last, proto.ErrBusy)
+				continue
+			}
+
+			if o.metered(indirect(o.p)) && !c.s.allowWrite() {
+				c.endRequest()
+				c.SendResponse(rid, proto.Last, proto.ErrBusy)
+				continue
+			}
+
+			c.lk.Lock()
+			c.cancels[rid] = make(chan bool)
+			c.lk.Unlock()
+
+			go c.handle(rid, o.f, indirect(o.p), timeout)
 			continue
 		}
 
-		rlogger.Printf("unknown command <%s>", verb)
+		rlg.Warn("unknown command", "verb", verb)
 		c.SendResponse(rid, proto.Last, os.ErrorString(proto.InvalidCommand+" "+verb))
 	}
 }
@@ -1,6 +1,367 @@
 package server
 
-import "testing"
+import (
+	"doozer/paxos"
+	"doozer/proto"
+	"doozer/store"
+	"github.com/bmizerany/assert"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
 
 func TestFoo(t *testing.T) {
 }
+
+func mustListen() net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func newTestServer() (*Server, net.Listener) {
+	l := mustListen()
+	sv := &Server{St: store.New()}
+	go sv.Serve(l, make(chan int))
+	return sv, l
+}
+
+func mustDial(l net.Listener) (net.Conn, *proto.Conn) {
+	c, err := net.Dial("tcp", "", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	pr := proto.NewConn(c)
+	go pr.ReadResponses()
+	return c, pr
+}
+
+// Pushes no-op mutations through the store until its watch count is `n`.
+// Closed watches are only dropped by the store when it next has an event
+// for them, so we have to keep generating events.
+func waitWatches(st *store.Store, seqn uint64, n int) uint64 {
+	for <-st.Watches != n {
+		seqn++
+		st.Ops <- store.Op{seqn, store.MustEncodeSet("/x", "", store.Clobber)}
+		st.Sync(seqn)
+	}
+	return seqn
+}
+
+func TestServerDisconnectDropsWatch(t *testing.T) {
+	sv, l := newTestServer()
+	defer l.Close()
+
+	c, pr := mustDial(l)
+	_, err := pr.SendRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+
+	for <-sv.St.Watches < 1 {
+	}
+
+	// Hang up without closing the watch.
+	c.Close()
+
+	waitWatches(sv.St, 0, 0)
+}
+
+func TestServerCloseDropsWatch(t *testing.T) {
+	sv, l := newTestServer()
+	defer l.Close()
+
+	_, pr := mustDial(l)
+	id, r, err := pr.StartRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+
+	for <-sv.St.Watches < 1 {
+	}
+
+	assert.Equal(t, nil, pr.Cancel(id))
+	<-r
+	assert.T(t, closed(r))
+
+	waitWatches(sv.St, 0, 0)
+}
+
+func TestServerDisconnectMidWalk(t *testing.T) {
+	sv, l := newTestServer()
+	defer l.Close()
+
+	for i := uint64(1); i <= 100; i++ {
+		sv.St.Ops <- store.Op{i, store.MustEncodeSet("/d/"+strconv.Uitoa64(i), "x", store.Clobber)}
+	}
+	sv.St.Sync(100)
+
+	c, pr := mustDial(l)
+	_, err := pr.SendRequest("WALK", "/d/*")
+	assert.Equal(t, nil, err)
+	c.Close()
+
+	// The server is still healthy.
+	_, pr = mustDial(l)
+	r, err := pr.SendRequest("STAT", "/d")
+	assert.Equal(t, nil, err)
+	var res proto.ResStat
+	assert.Equal(t, nil, r.Get(&res))
+	assert.Equal(t, 100, res.Children)
+}
+
+func TestServerWalkIsConsistent(t *testing.T) {
+	sv, l := newTestServer()
+	defer l.Close()
+
+	for i := uint64(1); i <= 100; i++ {
+		sv.St.Ops <- store.Op{i, store.MustEncodeSet("/d/"+strconv.Uitoa64(i), "x", store.Clobber)}
+	}
+	sv.St.Sync(100)
+
+	_, pr := mustDial(l)
+	r, err := pr.SendRequest("WALK", "/d/*")
+	assert.Equal(t, nil, err)
+
+	var res proto.ResWatch
+	assert.Equal(t, nil, r.Get(&res))
+
+	// Changes made once the walk has begun are not seen by it.
+	for i := uint64(1); i <= 100; i++ {
+		sv.St.Ops <- store.Op{100 + i, store.MustEncodeSet("/d/"+strconv.Uitoa64(i), "y", store.Clobber)}
+	}
+	sv.St.Ops <- store.Op{201, store.MustEncodeSet("/d/new", "y", store.Clobber)}
+	sv.St.Sync(201)
+
+	n := 1
+	for data := range r {
+		assert.Equal(t, nil, proto.Fit(data, &res))
+		assert.Equal(t, "x", res.Body, res.Path)
+		n++
+	}
+	assert.Equal(t, 100, n)
+}
+
+func TestConnPoisonedAfterDisconnect(t *testing.T) {
+	sv, l := newTestServer()
+	defer l.Close()
+
+	c, pr := mustDial(l)
+	_, r, err := pr.StartRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+
+	for <-sv.St.Watches < 1 {
+	}
+
+	c.Close()
+
+	// The waiting request gets an error, then is closed.
+	_, ok := (<-r).(*proto.ProtoError)
+	assert.T(t, ok)
+	<-r
+	assert.T(t, closed(r))
+
+	// New requests fail without trying to write.
+	_, err = pr.SendRequest("NOOP", nil)
+	assert.NotEqual(t, nil, err)
+}
+
+// A Manager that never gets a value chosen, as when there is no quorum. It
+// gives up when the proposal is cancelled, and says so on `gaveUp` if set.
+type stuckManager struct {
+	gaveUp chan bool
+}
+
+func (m stuckManager) Propose(v string, cancel <-chan bool) (uint64, string, os.Error) {
+	<-cancel
+	if m.gaveUp != nil {
+		m.gaveUp <- true
+	}
+	return 0, "", paxos.ErrCancelled
+}
+
+func (m stuckManager) ProposeOnce(v string, cancel <-chan bool) store.Event {
+	_, _, err := m.Propose(v, cancel)
+	return store.Event{Err: err}
+}
+
+func (stuckManager) PutFrom(string, paxos.Msg) {}
+
+func (stuckManager) Alpha() int {
+	return 1
+}
+
+func TestServerTimeout(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+
+	cal := make(chan int)
+	close(cal)
+	<-cal
+	mg := stuckManager{make(chan bool, 1)}
+	sv := &Server{St: store.New(), Mg: mg}
+	go sv.Serve(l, cal)
+
+	_, pr := mustDial(l)
+	_, r, err := pr.StartTimedRequest("SET", proto.ReqSet{"/x", "a", store.Clobber}, 1e8)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, proto.ErrTimeout, r.Get(nil))
+
+	// The write is no longer being proposed.
+	<-mg.gaveUp
+
+	// Untimed requests are unaffected.
+	r, err = pr.SendRequest("STAT", "/x")
+	assert.Equal(t, nil, err)
+	var res proto.ResStat
+	assert.Equal(t, nil, r.Get(&res))
+}
+
+func TestServerBadRequestKeepsConn(t *testing.T) {
+	_, l := newTestServer()
+	defer l.Close()
+
+	c, pr := mustDial(l)
+
+	// A STAT with a negative timeout.
+	_, err := c.Write([]byte("*4\r\n+STAT\r\n:100\r\n$2\r\n/x\r\n:-1\r\n"))
+	assert.Equal(t, nil, err)
+
+	r, err := pr.SendRequest("STAT", "/x")
+	assert.Equal(t, nil, err)
+	var res proto.ResStat
+	assert.Equal(t, nil, r.Get(&res))
+}
+
+func TestServerTooManyRequests(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+
+	cal := make(chan int)
+	close(cal)
+	<-cal
+	sv := &Server{St: store.New(), Mg: stuckManager{}, Limits: Limits{Requests: 1}}
+	go sv.Serve(l, cal)
+
+	_, pr := mustDial(l)
+	_, err := pr.SendRequest("SET", proto.ReqSet{"/x", "a", store.Clobber})
+	assert.Equal(t, nil, err)
+
+	r, err := pr.SendRequest("STAT", "/x")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, proto.ErrBusy, r.Get(nil))
+}
+
+func TestServerBusyTakesNoWriteToken(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+
+	cal := make(chan int)
+	close(cal)
+	<-cal
+	sv := &Server{St: store.New(), Limits: Limits{Requests: 1, WriteRate: 1}}
+	go sv.Serve(l, cal)
+
+	_, pr := mustDial(l)
+	_, err := pr.SendRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+
+	for <-sv.St.Watches < 1 {
+	}
+
+	r, err := pr.SendRequest("SET", proto.ReqSet{"/x", "a", store.Clobber})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, proto.ErrBusy, r.Get(nil))
+
+	sv.lk.Lock()
+	defer sv.lk.Unlock()
+	assert.Equal(t, int64(0), sv.last)
+}
+
+func TestServerMemberWritesUnmetered(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+
+	cal := make(chan int)
+	close(cal)
+	<-cal
+	sv := &Server{St: store.New(), Mg: stuckManager{}, Limits: Limits{WriteRate: 1}}
+	sv.last = time.Nanoseconds() // no tokens left
+	go sv.Serve(l, cal)
+
+	_, pr := mustDial(l)
+	r, err := pr.SendRequest("SET", proto.ReqSet{"/x", "a", store.Clobber})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, proto.ErrBusy, r.Get(nil))
+
+	// Members' own requests still get through, and wait on the stuck
+	// proposal.
+	reqs := []struct {
+		verb string
+		data interface{}
+	}{
+		{"checkin", proto.ReqCheckin{"a", store.Clobber}},
+		{"NOOP", nil},
+		{"SET", proto.ReqSet{"/doozer/slot/1", "a", store.Clobber}},
+	}
+	for _, req := range reqs {
+		_, r, err = pr.StartTimedRequest(req.verb, req.data, 1e8)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, proto.ErrTimeout, r.Get(nil), req.verb)
+	}
+}
+
+func TestServerTooManyWatches(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+	sv := &Server{St: store.New(), Limits: Limits{Watches: 1}}
+	go sv.Serve(l, make(chan int))
+
+	_, pr := mustDial(l)
+	_, err := pr.SendRequest("WATCH", "/x")
+	assert.Equal(t, nil, err)
+
+	for <-sv.St.Watches < 1 {
+	}
+
+	r, err := pr.SendRequest("WATCH", "/y")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, proto.ErrBusy, r.Get(nil))
+}
+
+func TestServerTooManyConns(t *testing.T) {
+	l := mustListen()
+	defer l.Close()
+	sv := &Server{St: store.New(), Limits: Limits{Conns: 1}}
+	go sv.Serve(l, make(chan int))
+
+	_, pr := mustDial(l)
+	r, err := pr.SendRequest("STAT", "/x")
+	assert.Equal(t, nil, err)
+	var res proto.ResStat
+	assert.Equal(t, nil, r.Get(&res))
+
+	_, pr2 := mustDial(l)
+	r, err = pr2.SendRequest("STAT", "/x")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, proto.ErrBusy, r.Get(&res))
+}
+
+func TestServerWriteRate(t *testing.T) {
+	sv := &Server{Limits: Limits{WriteRate: 2}}
+	assert.T(t, sv.allowWrite())
+	assert.T(t, sv.allowWrite())
+	assert.T(t, !sv.allowWrite())
+
+	// Half a second later, there is room for one more.
+	sv.last -= 5e8
+	assert.T(t, sv.allowWrite())
+	assert.T(t, !sv.allowWrite())
+}
+
+func TestServerNoLimits(t *testing.T) {
+	sv := &Server{}
+	for i := 0; i < 1000; i++ {
+		assert.T(t, sv.allowWrite())
+	}
+}
@@ -7,9 +7,9 @@ import (
 )
 
 func Clean(s *store.Store, p paxos.Proposer) {
-	timer := timer.New("/session/**", timer.OneSecond, s)
+	timer := timer.New("/session/**", s)
 	for tick := range timer.C {
 		_, cas := s.Get(tick.Path)
-		paxos.Del(p, tick.Path, cas)
+		paxos.Del(p, tick.Path, cas, nil)
 	}
 }
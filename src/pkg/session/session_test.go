@@ -21,11 +21,11 @@ func TestSession(t *testing.T) {
 			ch <- e
 		}
 		close(ch)
-	}(st.Watch("/session/*"))
+	}(st.MustWatch("/session/*"))
 
 	// check-in with less than a nanosecond to live
 	body := strconv.Itoa64(time.Nanoseconds() + 1)
-	fp.Propose(store.MustEncodeSet("/session/a", body, store.Clobber))
+	fp.Propose(store.MustEncodeSet("/session/a", body, store.Clobber), nil)
 
 	// Throw away the set
 	assert.T(t, (<-ch).IsSet())
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Kinds of event, for Filter.Kinds.
+const (
+	SetEvents = 1 << iota
+	DelEvents
+)
+
+type BadPatternError struct {
+	Pattern string
+}
+
+func (e *BadPatternError) String() string {
+	return "bad pattern: " + e.Pattern
+}
+
+// Describes which events a watch will receive. An event is sent iff its path
+// matches at least one of Globs and none of Exclude, its kind is in Kinds,
+// and its path has no more than Depth components.
+//
+// A Kinds of 0 means all kinds. A Depth of 0 means no limit. Dummy events,
+// such as the one for a Nop, are not filtered by kind or depth.
+//
+// Patterns are globs as described for Watch.
+type Filter struct {
+	Globs   []string
+	Exclude []string
+	Kinds   int
+	Depth   int
+}
+
+type filter struct {
+	in    []*regexp.Regexp
+	ex    []*regexp.Regexp
+	kinds int
+	depth int
+
+	// The literal prefix of each glob in `in`. See watchIndex.
+	prefixes [][]string
+}
+
+// Returns a `BadPatternError` if `pattern` could never match a valid path,
+// or contains something other than wildcards between valid components.
+func checkGlob(pattern string) os.Error {
+	if pattern == "/" {
+		return nil
+	}
+
+	if pattern == "" || (pattern[0] != '/' && pattern[0] != '*') {
+		return &BadPatternError{pattern}
+	}
+
+	cs := strings.Split(pattern, "/", -1)
+	if pattern[0] == '/' {
+		cs = cs[1:]
+	}
+
+	for _, c := range cs {
+		lit := strings.Map(func(r int) int {
+			if r == '*' || r == '?' {
+				return -1
+			}
+			return r
+		}, c)
+
+		if c == "" || (lit != "" && !checkComponent(lit)) {
+			return &BadPatternError{pattern}
+		}
+	}
+
+	return nil
+}
+
+func compileGlobs(patterns []string) ([]*regexp.Regexp, os.Error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		if err := checkGlob(p); err != nil {
+			return nil, err
+		}
+
+		re, err := compileGlob(p)
+		if err != nil {
+			return nil, &BadPatternError{p}
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+func (f Filter) compile() (*filter, os.Error) {
+	if len(f.Globs) == 0 {
+		return nil, &BadPatternError{""}
+	}
+
+	if f.Kinds&^(SetEvents|DelEvents) != 0 || f.Depth < 0 {
+		return nil, os.EINVAL
+	}
+
+	in, err := compileGlobs(f.Globs)
+	if err != nil {
+		return nil, err
+	}
+
+	ex, err := compileGlobs(f.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([][]string, len(f.Globs))
+	for i, g := range f.Globs {
+		prefixes[i] = globPrefix(g)
+	}
+
+	return &filter{in, ex, f.Kinds, f.Depth, prefixes}, nil
+}
+
+func anyMatch(res []*regexp.Regexp, path string) bool {
+	for _, re := range res {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the number of components in `path`.
+func depth(path string) int {
+	if path == "/" {
+		return 0
+	}
+	return strings.Count(path, "/")
+}
+
+func (f *filter) match(ev Event) bool {
+	if !anyMatch(f.in, ev.Path) || anyMatch(f.ex, ev.Path) {
+		return false
+	}
+
+	if ev.IsDummy() {
+		return true
+	}
+
+	switch {
+	case f.kinds != 0 && ev.IsSet() && f.kinds&SetEvents == 0:
+		return false
+	case f.kinds != 0 && ev.IsDel() && f.kinds&DelEvents == 0:
+		return false
+	case f.depth != 0 && depth(ev.Path) > f.depth:
+		return false
+	}
+
+	return true
+}
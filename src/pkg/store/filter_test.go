@@ -0,0 +1,137 @@
+package store
+
+import (
+	"github.com/bmizerany/assert"
+	"os"
+	"testing"
+)
+
+var goodGlobs = []string{
+	"/",
+	"**",
+	"/**",
+	"/x",
+	"/x/*",
+	"/x/**/y",
+	"/x?",
+	"/a*b",
+	"/a%3A*",
+}
+
+var badGlobs = []string{
+	"",
+	"x",
+	"/x/",
+	"/x//y",
+	"/x y",
+	"/x:y",
+	"/a%3",
+	"/a%3a*",
+}
+
+func TestCheckGoodGlobs(t *testing.T) {
+	for _, g := range goodGlobs {
+		assert.Equalf(t, nil, checkGlob(g), "for glob %q", g)
+	}
+}
+
+func TestCheckBadGlobs(t *testing.T) {
+	for _, g := range badGlobs {
+		err := checkGlob(g)
+		_, ok := err.(*BadPatternError)
+		assert.Tf(t, ok, "for glob %q, got %T: %v", g, err, err)
+	}
+}
+
+func TestFilterCompileErrors(t *testing.T) {
+	_, err := Filter{}.compile()
+	assert.Equal(t, &BadPatternError{""}, err)
+
+	_, err = Filter{Globs: []string{"/x y"}}.compile()
+	assert.Equal(t, &BadPatternError{"/x y"}, err)
+
+	_, err = Filter{Globs: []string{"/**"}, Exclude: []string{"x"}}.compile()
+	assert.Equal(t, &BadPatternError{"x"}, err)
+
+	_, err = Filter{Globs: []string{"/**"}, Kinds: 4}.compile()
+	assert.Equal(t, os.EINVAL, err)
+
+	_, err = Filter{Globs: []string{"/**"}, Depth: -1}.compile()
+	assert.Equal(t, os.EINVAL, err)
+}
+
+func mustFilter(f Filter) *filter {
+	cf, err := f.compile()
+	if err != nil {
+		panic(err)
+	}
+	return cf
+}
+
+func TestFilterGlobs(t *testing.T) {
+	f := mustFilter(Filter{Globs: []string{"/a/*", "/b/**"}})
+	assert.T(t, f.match(Event{Path: "/a/x", Cas: "1"}))
+	assert.T(t, f.match(Event{Path: "/b/x/y", Cas: "1"}))
+	assert.T(t, !f.match(Event{Path: "/a/x/y", Cas: "1"}))
+	assert.T(t, !f.match(Event{Path: "/c", Cas: "1"}))
+}
+
+func TestFilterExclude(t *testing.T) {
+	f := mustFilter(Filter{Globs: []string{"/**"}, Exclude: []string{"/doozer/**"}})
+	assert.T(t, f.match(Event{Path: "/x", Cas: "1"}))
+	assert.T(t, !f.match(Event{Path: "/doozer/slot/1", Cas: "1"}))
+}
+
+func TestFilterKinds(t *testing.T) {
+	set := Event{Path: "/x", Cas: "1"}
+	del := Event{Path: "/x", Cas: Missing}
+
+	f := mustFilter(Filter{Globs: []string{"/**"}, Kinds: SetEvents})
+	assert.T(t, f.match(set))
+	assert.T(t, !f.match(del))
+
+	f = mustFilter(Filter{Globs: []string{"/**"}, Kinds: DelEvents})
+	assert.T(t, !f.match(set))
+	assert.T(t, f.match(del))
+
+	f = mustFilter(Filter{Globs: []string{"/**"}, Kinds: SetEvents | DelEvents})
+	assert.T(t, f.match(set))
+	assert.T(t, f.match(del))
+}
+
+func TestFilterDepth(t *testing.T) {
+	f := mustFilter(Filter{Globs: []string{"/**"}, Depth: 2})
+	assert.T(t, f.match(Event{Path: "/x", Cas: "1"}))
+	assert.T(t, f.match(Event{Path: "/x/y", Cas: "1"}))
+	assert.T(t, !f.match(Event{Path: "/x/y/z", Cas: "1"}))
+}
+
+func TestFilterDummy(t *testing.T) {
+	f := mustFilter(Filter{Globs: []string{"**"}, Kinds: DelEvents, Depth: 1})
+	assert.T(t, f.match(Event{Seqn: 1, Mut: Nop}))
+}
+
+func TestWatchFilter(t *testing.T) {
+	st := New()
+	ch, err := st.WatchFilter(Filter{
+		Globs:   []string{"/x/**"},
+		Exclude: []string{"/x/skip"},
+		Kinds:   SetEvents,
+	})
+	assert.Equal(t, nil, err)
+
+	st.Ops <- Op{1, MustEncodeSet("/x/skip", "a", Clobber)}
+	st.Ops <- Op{2, MustEncodeSet("/x/a", "a", Clobber)}
+	st.Ops <- Op{3, MustEncodeDel("/x/a", Clobber)}
+	st.Ops <- Op{4, MustEncodeSet("/x/b", "b", Clobber)}
+
+	assert.Equal(t, "/x/a", (<-ch).Path)
+	assert.Equal(t, "/x/b", (<-ch).Path)
+}
+
+func TestWatchFilterBad(t *testing.T) {
+	st := New()
+	ch, err := st.WatchFilter(Filter{Globs: []string{"x"}})
+	assert.Equal(t, (<-chan Event)(nil), ch)
+	assert.Equal(t, &BadPatternError{"x"}, err)
+}
@@ -63,6 +63,10 @@ func walk(g Getter, path string, re *regexp.Regexp, ch chan Event) {
 func Walk(g Getter, pattern string) (chan Event, os.Error) {
 	ch := make(chan Event)
 
+	if err := checkGlob(pattern); err != nil {
+		return nil, err
+	}
+
 	// TODO find the longest non-glob prefix of pattern and start there
 	re, err := compileGlob(pattern)
 	if err != nil {
@@ -64,3 +64,8 @@ func TestWalk(t *testing.T) {
 	}
 	assert.Equal(t, 0, len(exp))
 }
+
+func TestWalkBadPattern(t *testing.T) {
+	_, err := Walk(New(), "x")
+	assert.Equal(t, &BadPatternError{"x"}, err)
+}
@@ -10,6 +10,10 @@ import (
 //  - `?` matches a single char in a single path component
 //  - `*` matches zero or more chars in a single path component
 //  - `**` matches zero or more chars in zero or more components
+//
+// Any other character matches itself. Patterns are written in the same
+// escaped form as paths, so a literal `*` or `?` in a name is written `%2A`
+// or `%3F`.
 func translateGlob(pattern string) (regexp string) {
 	outs := make([]string, len(pattern))
 	i, double := 0, false
@@ -18,8 +22,8 @@ func translateGlob(pattern string) (regexp string) {
 		default:
 			outs[i] = string(c)
 			double = false
-		case '.':
-			outs[i] = `\.`
+		case '.', '+', '(', ')', '[', ']', '{', '}', '^', '$', '|', '\\':
+			outs[i] = `\` + string(c)
 			double = false
 		case '?':
 			outs[i] = `[^/]`
@@ -21,6 +21,8 @@ var globs = [][]string{
 	{"/*a*/b", `^/[^/]*a[^/]*/b$`},
 	{"/**", `^/.*$`},
 	{"/**/a", `^/.*/a$`},
+	{"/a+(b)", `^/a\+\(b\)$`},
+	{"/a%3Ab", `^/a%3Ab$`},
 }
 
 var matches = [][]string{
@@ -28,6 +30,8 @@ var matches = [][]string{
 	{"/a?", "/ab", "/ac"},
 	{"/a*", "/a", "/ab", "/abc"},
 	{"/a**", "/a", "/ab", "/abc", "/a/", "/a/b", "/ab/c"},
+	{"/a+b", "/a+b"},
+	{"/a$", "/a$"},
 }
 
 var nonMatches = [][]string{
@@ -35,6 +39,7 @@ var nonMatches = [][]string{
 	{"/a?", "/", "/abc", "/a", "/a/"},
 	{"/a*", "/", "/a/", "/ba"},
 	{"/a**", "/", "/ba"},
+	{"/a+b", "/aab", "/ab"},
 }
 
 func TestGlobTranslate(t *testing.T) {
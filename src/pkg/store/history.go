@@ -0,0 +1,151 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Files in this directory configure how much history the store keeps. Each
+// file's body is a retention policy of the form
+//
+//   <prefix> <count> [<since>]
+//
+// which means: for every file at or below `prefix`, keep the last `count`
+// versions (0 means no limit), discarding any from before seqn `since`. If
+// more than one policy covers a path, the one with the longest prefix wins.
+//
+// History is kept by each node as it applies mutations. It is not included
+// in snapshots, so a node only has history from the time it joined. The
+// policies are, and take effect as soon as the snapshot is applied.
+const HistoryPath = "/store/history"
+
+// One past value of a file. A deletion is recorded with Cas set to Missing.
+type Version struct {
+	Seqn uint64
+	Body string
+	Cas  string
+}
+
+type retention struct {
+	prefix string
+	count  int
+	since  uint64
+}
+
+type histReq struct {
+	path string
+	ch   chan []Version
+}
+
+func parseRetention(body string) (r retention, ok bool) {
+	fields := strings.Fields(body)
+	if len(fields) < 2 || len(fields) > 3 || checkPath(fields[0]) != nil {
+		return retention{}, false
+	}
+
+	r.prefix = fields[0]
+
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n < 0 {
+		return retention{}, false
+	}
+	r.count = n
+
+	if len(fields) == 3 {
+		r.since, err = strconv.Atoui64(fields[2])
+		if err != nil {
+			return retention{}, false
+		}
+	}
+
+	return r, true
+}
+
+func (r retention) covers(path string) bool {
+	return r.prefix == "/" || path == r.prefix ||
+		strings.HasPrefix(path, r.prefix+"/")
+}
+
+// Discards versions in `vs` that r does not allow us to keep.
+func (r retention) trim(vs []Version) []Version {
+	for len(vs) > 0 && vs[0].Seqn < r.since {
+		vs = vs[1:]
+	}
+
+	if r.count > 0 && len(vs) > r.count {
+		vs = vs[len(vs)-r.count:]
+	}
+
+	return vs
+}
+
+// Returns the policy that applies to `path`, if any.
+func (st *Store) policy(path string) (r retention, ok bool) {
+	for _, p := range st.retain {
+		if p.covers(path) && len(p.prefix) >= len(r.prefix) {
+			r, ok = p, true
+		}
+	}
+	return r, ok
+}
+
+// Updates history for the change described by `ev`. Called from process.
+func (st *Store) record(ev Event) {
+	if ev.IsDummy() || ev.Err != nil {
+		return
+	}
+
+	if strings.HasPrefix(ev.Path, HistoryPath+"/") {
+		st.configure(ev)
+	}
+
+	r, ok := st.policy(ev.Path)
+	if !ok {
+		return
+	}
+
+	vs := append(st.hist[ev.Path], Version{ev.Seqn, ev.Body, ev.Cas})
+	st.hist[ev.Path] = r.trim(vs)
+}
+
+func (st *Store) configure(ev Event) {
+	name := ev.Path[len(HistoryPath)+1:]
+
+	st.retain[name] = retention{}, false
+	if ev.IsSet() {
+		if r, ok := parseRetention(ev.Body); ok {
+			st.retain[name] = r
+		}
+	}
+
+	// Drop or trim whatever the new set of policies says we shouldn't keep.
+	for path, vs := range st.hist {
+		if r, ok := st.policy(path); ok {
+			st.hist[path] = r.trim(vs)
+		} else {
+			st.hist[path] = nil, false
+		}
+	}
+}
+
+// Replaces the policies with those in HistoryPath of `g`, and forgets all
+// history. Called from process once a snapshot is applied, since its
+// policies come without events.
+func (st *Store) reconfigure(g Getter) {
+	st.retain = make(map[string]retention)
+	for _, name := range GetDir(g, HistoryPath) {
+		if r, ok := parseRetention(GetString(g, HistoryPath+"/"+name)); ok {
+			st.retain[name] = r
+		}
+	}
+	st.hist = make(map[string][]Version)
+}
+
+// Returns the recorded versions of the file at `path`, oldest first. The
+// last version is the current one, unless the file has since been deleted.
+// Only paths covered by a policy in HistoryPath have history.
+func (st *Store) History(path string) []Version {
+	ch := make(chan []Version)
+	st.histCh <- histReq{path, ch}
+	return <-ch
+}
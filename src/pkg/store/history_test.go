@@ -0,0 +1,110 @@
+package store
+
+import (
+	"github.com/bmizerany/assert"
+	"strconv"
+	"testing"
+)
+
+func TestParseRetention(t *testing.T) {
+	r, ok := parseRetention("/x 3")
+	assert.T(t, ok)
+	assert.Equal(t, retention{"/x", 3, 0}, r)
+
+	r, ok = parseRetention("/x 0 10")
+	assert.T(t, ok)
+	assert.Equal(t, retention{"/x", 0, 10}, r)
+}
+
+func TestParseRetentionBad(t *testing.T) {
+	for _, body := range []string{"", "/x", "x 1", "/x -1", "/x a", "/x 1 a", "/x 1 2 3"} {
+		_, ok := parseRetention(body)
+		assert.Equal(t, false, ok, body)
+	}
+}
+
+func TestRetentionCovers(t *testing.T) {
+	r := retention{prefix: "/x"}
+	assert.T(t, r.covers("/x"))
+	assert.T(t, r.covers("/x/y"))
+	assert.Equal(t, false, r.covers("/xy"))
+	assert.T(t, retention{prefix: "/"}.covers("/xy"))
+}
+
+func TestRetentionTrim(t *testing.T) {
+	vs := []Version{{1, "a", "1"}, {2, "b", "2"}, {3, "c", "3"}}
+	assert.Equal(t, vs[1:], retention{"/", 2, 0}.trim(vs))
+	assert.Equal(t, vs[2:], retention{"/", 0, 3}.trim(vs))
+	assert.Equal(t, vs, retention{"/", 0, 0}.trim(vs))
+}
+
+func TestHistoryNone(t *testing.T) {
+	st := New()
+	st.Ops <- Op{1, MustEncodeSet("/x", "a", Clobber)}
+	st.Sync(1)
+	assert.Equal(t, []Version{}, st.History("/x"))
+}
+
+func TestHistoryCount(t *testing.T) {
+	st := New()
+	st.Ops <- Op{1, MustEncodeSet(HistoryPath+"/x", "/x 2", Clobber)}
+	st.Ops <- Op{2, MustEncodeSet("/x", "a", Clobber)}
+	st.Ops <- Op{3, MustEncodeSet("/x", "b", Clobber)}
+	st.Ops <- Op{4, MustEncodeDel("/x", Clobber)}
+	st.Ops <- Op{5, MustEncodeSet("/y", "c", Clobber)}
+	st.Sync(5)
+
+	exp := []Version{{3, "b", "3"}, {4, "", Missing}}
+	assert.Equal(t, exp, st.History("/x"))
+	assert.Equal(t, []Version{}, st.History("/y"))
+}
+
+func TestHistorySince(t *testing.T) {
+	st := New()
+	st.Ops <- Op{1, MustEncodeSet(HistoryPath+"/x", "/x 0 3", Clobber)}
+	st.Ops <- Op{2, MustEncodeSet("/x/y", "a", Clobber)}
+	st.Ops <- Op{3, MustEncodeSet("/x/y", "b", Clobber)}
+	st.Sync(3)
+
+	assert.Equal(t, []Version{{3, "b", "3"}}, st.History("/x/y"))
+}
+
+func TestHistoryLongestPrefix(t *testing.T) {
+	st := New()
+	st.Ops <- Op{1, MustEncodeSet(HistoryPath+"/all", "/ 0", Clobber)}
+	st.Ops <- Op{2, MustEncodeSet(HistoryPath+"/x", "/x 1", Clobber)}
+	st.Ops <- Op{3, MustEncodeSet("/x", "a", Clobber)}
+	st.Ops <- Op{4, MustEncodeSet("/x", "b", Clobber)}
+	st.Ops <- Op{5, MustEncodeSet("/y", "c", Clobber)}
+	st.Ops <- Op{6, MustEncodeSet("/y", "d", Clobber)}
+	st.Sync(6)
+
+	assert.Equal(t, []Version{{4, "b", "4"}}, st.History("/x"))
+	assert.Equal(t, []Version{{5, "c", "5"}, {6, "d", "6"}}, st.History("/y"))
+}
+
+func TestHistoryPolicyRemoved(t *testing.T) {
+	st := New()
+	st.Ops <- Op{1, MustEncodeSet(HistoryPath+"/x", "/x 0", Clobber)}
+	st.Ops <- Op{2, MustEncodeSet("/x", "a", Clobber)}
+	st.Ops <- Op{3, MustEncodeDel(HistoryPath+"/x", Clobber)}
+	st.Ops <- Op{4, MustEncodeSet("/x", "b", Clobber)}
+	st.Sync(4)
+
+	assert.Equal(t, []Version{}, st.History("/x"))
+}
+
+func TestHistoryFromSnapshot(t *testing.T) {
+	old := New()
+	old.Ops <- Op{1, MustEncodeSet(HistoryPath+"/x", "/x 0", Clobber)}
+	old.Sync(1)
+	seqn, snap := old.Snapshot()
+
+	st := New()
+	st.Ops <- Op{1, snap}
+	st.Ops <- Op{seqn + 1, MustEncodeSet("/x", "a", Clobber)}
+	st.Sync(seqn + 1)
+
+	exp := []Version{{seqn + 1, "a", strconv.Uitoa64(seqn + 1)}}
+	assert.Equal(t, exp, st.History("/x"))
+}
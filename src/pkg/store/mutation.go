@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"strings"
+)
+
+// Mutations are encoded in a binary format that can hold arbitrary bytes in
+// every field:
+//
+//   marker   4 bytes, "\x00mut"
+//   version  1 byte
+//   kind     1 byte
+//   count    1 byte
+//   field    count times; a 4-byte big-endian length, then that many bytes
+//
+// In version 1, the fields for each kind are:
+//
+//   set      cas, path, body
+//   del      cas, path
+//   deltree  cas, path
+//   move     cas, from, to
+//
+// A node rejects a mutation with an unknown version or kind as a bad
+// mutation, so a new kind must not be proposed until every node in the
+// cluster understands it.
+//
+// Sets and deletes are still proposed in the older text format, "cas:path=body"
+// and "cas:path", which every node understands. Only a cas that the text
+// format can't hold falls back to the binary format.
+const (
+	mutMarker  = "\x00mut"
+	mutVersion = 1
+)
+
+// Kinds of mutation.
+const (
+	mutSet = iota + 1
+	mutDel
+	mutDelTree
+	mutMove
+)
+
+// The number of fields each kind of mutation has.
+var mutFields = map[int]int{
+	mutSet:     3,
+	mutDel:     2,
+	mutDelTree: 2,
+	mutMove:    3,
+}
+
+type mutation struct {
+	kind  int
+	cas   string
+	paths []string
+	body  string
+}
+
+func (m mutation) encode() string {
+	fields := []string{m.cas}
+	fields = append(fields, m.paths...)
+	if m.kind == mutSet {
+		fields = append(fields, m.body)
+	}
+
+	w := new(bytes.Buffer)
+	w.WriteString(mutMarker)
+	w.WriteByte(mutVersion)
+	w.WriteByte(byte(m.kind))
+	w.WriteByte(byte(len(fields)))
+	for _, f := range fields {
+		writeField(w, f)
+	}
+	return w.String()
+}
+
+// Encodes set or del mutation `m` in the text format, unless its cas would
+// make that ambiguous.
+func encodeText(m mutation) string {
+	s := m.cas + ":" + m.paths[0]
+	if m.kind == mutSet {
+		s += "=" + m.body
+	}
+
+	if strings.Contains(m.cas, ":") || IsSnapshot(s) || strings.HasPrefix(s, mutMarker) {
+		return m.encode()
+	}
+	return s
+}
+
+// Decodes a mutation in either the binary or the text format. Does not
+// handle snapshots or Nop.
+func decodeMut(s string) (m mutation, err os.Error) {
+	if !strings.HasPrefix(s, mutMarker) {
+		return decodeText(s)
+	}
+
+	s = s[len(mutMarker):]
+	if len(s) < 3 || s[0] != mutVersion {
+		return mutation{}, ErrBadMutation
+	}
+
+	m.kind = int(s[1])
+	n, ok := mutFields[m.kind]
+	if !ok || int(s[2]) != n {
+		return mutation{}, ErrBadMutation
+	}
+	s = s[3:]
+
+	fields := make([]string, n)
+	for i := range fields {
+		fields[i], s, err = readField(s)
+		if err != nil {
+			return mutation{}, ErrBadMutation
+		}
+	}
+
+	if len(s) > 0 {
+		return mutation{}, ErrBadMutation
+	}
+
+	m.cas, fields = fields[0], fields[1:]
+	if m.kind == mutSet {
+		m.body, fields = fields[len(fields)-1], fields[0:len(fields)-1]
+	}
+	m.paths = fields
+
+	for _, p := range m.paths {
+		if err = checkPath(p); err != nil {
+			return mutation{}, err
+		}
+	}
+
+	return m, nil
+}
+
+func decodeText(s string) (m mutation, err os.Error) {
+	path, body, cas, keep, err := decode(s)
+	if err != nil {
+		return mutation{}, err
+	}
+
+	m = mutation{kind: mutDel, cas: cas, paths: []string{path}}
+	if keep {
+		m.kind, m.body = mutSet, body
+	}
+	return m, nil
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"github.com/bmizerany/assert"
+	"strings"
+	"testing"
+)
+
+var binaryBodies = []string{
+	"",
+	"a",
+	"a=b:c",
+	"\x00",
+	"\x00mut\x01\x01\x03",
+	"\xff\xfe\n\r\t",
+}
+
+func TestMutationRoundTrip(t *testing.T) {
+	muts := []mutation{
+		{mutSet, Clobber, []string{"/x"}, "a"},
+		{mutSet, "123", []string{"/x/y"}, ""},
+		{mutDel, Missing, []string{"/x"}, ""},
+		{mutDelTree, "5", []string{"/"}, ""},
+		{mutMove, Clobber, []string{"/a", "/b"}, ""},
+	}
+
+	for _, m := range muts {
+		got, err := decodeMut(m.encode())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, m, got)
+	}
+}
+
+func TestMutationBinaryBody(t *testing.T) {
+	for _, body := range binaryBodies {
+		got, err := decodeMut(MustEncodeSet("/x", body, Clobber))
+		assert.Equal(t, nil, err)
+		assert.Equalf(t, body, got.body, "for body %q", body)
+	}
+}
+
+func TestMutationApplyBinaryBody(t *testing.T) {
+	for _, body := range binaryBodies {
+		r, ev := emptyDir.apply(1, MustEncodeSet("/x", body, Clobber))
+		assert.Equal(t, nil, ev.Err)
+		assert.Equal(t, body, ev.Body)
+		v, _ := r.Get("/x")
+		assert.Equal(t, []string{body}, v)
+	}
+}
+
+func TestMutationCasWithColon(t *testing.T) {
+	m := MustEncodeSet("/x", "a", "1:/y=b")
+	assert.T(t, strings.HasPrefix(m, mutMarker))
+	got, err := decodeMut(m)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mutation{mutSet, "1:/y=b", []string{"/x"}, "a"}, got)
+}
+
+func TestMutationAmbiguousCas(t *testing.T) {
+	for _, cas := range []string{"snap", mutMarker} {
+		m := MustEncodeDel("/x", cas)
+		assert.Tf(t, strings.HasPrefix(m, mutMarker), "for cas %q", cas)
+		got, err := decodeMut(m)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, mutation{mutDel, cas, []string{"/x"}, ""}, got)
+	}
+}
+
+func TestMutationBadVersion(t *testing.T) {
+	m := mutation{mutSet, Clobber, []string{"/x"}, "a"}.encode()
+	m = m[0:len(mutMarker)] + "\x02" + m[len(mutMarker)+1:]
+	_, err := decodeMut(m)
+	assert.Equal(t, ErrBadMutation, err)
+}
+
+func TestMutationBadKind(t *testing.T) {
+	m := mutation{mutSet, Clobber, []string{"/x"}, "a"}.encode()
+	m = m[0:len(mutMarker)+1] + "\x09" + m[len(mutMarker)+2:]
+	_, err := decodeMut(m)
+	assert.Equal(t, ErrBadMutation, err)
+}
+
+func TestMutationBadFieldCount(t *testing.T) {
+	m := mutation{mutSet, Clobber, []string{"/x"}, "a"}.encode()
+	m = m[0:len(mutMarker)+2] + "\x02" + m[len(mutMarker)+3:]
+	_, err := decodeMut(m)
+	assert.Equal(t, ErrBadMutation, err)
+}
+
+func TestMutationTruncated(t *testing.T) {
+	m := mutation{mutSet, Clobber, []string{"/x"}, "abc"}.encode()
+	for i := len(mutMarker); i < len(m); i++ {
+		_, err := decodeMut(m[0:i])
+		assert.Equalf(t, ErrBadMutation, err, "at length %d", i)
+	}
+}
+
+func TestMutationTrailingBytes(t *testing.T) {
+	m := mutation{mutSet, Clobber, []string{"/x"}, "a"}.encode()
+	_, err := decodeMut(m + "z")
+	assert.Equal(t, ErrBadMutation, err)
+}
+
+func TestMutationBadPath(t *testing.T) {
+	m := mutation{mutSet, Clobber, []string{"/x y"}, "a"}
+	_, err := decodeMut(m.encode())
+	_, ok := err.(*BadPathError)
+	assert.Tf(t, ok, "got %T: %v", err, err)
+}
+
+func TestApplyBadKind(t *testing.T) {
+	m := mutation{mutDel, Clobber, []string{"/x"}, ""}.encode()
+	m = m[0:len(mutMarker)+1] + "\x09" + m[len(mutMarker)+2:]
+	_, ev := emptyDir.apply(1, m)
+	assert.Equal(t, ErrBadMutation, ev.Err)
+	assert.Equal(t, ErrorPath, ev.Path)
+}
@@ -1,10 +1,8 @@
 package store
 
 import (
-	"gob"
 	"os"
 	"strconv"
-	"strings"
 )
 
 var emptyDir = node{v: "", ds: make(map[string]node), cas: Dir}
@@ -18,6 +16,11 @@ type node struct {
 	v   string
 	cas string
 	ds  map[string]node
+
+	// For files only: the seqn at which the file was created, and the number
+	// of times it has been set since then.
+	created uint64
+	ver     int
 }
 
 func (n node) String() string {
@@ -70,41 +73,58 @@ func copyMap(a map[string]node) map[string]node {
 }
 
 // Return value is replacement node
-func (n node) set(parts []string, v, cas string, keep bool) (node, bool) {
+func (n node) set(parts []string, v, cas string, seqn uint64, keep bool) (node, bool) {
 	if len(parts) == 0 {
-		return node{v, cas, n.ds}, keep
+		if n.cas == "" {
+			return node{v, cas, n.ds, seqn, 1}, keep
+		}
+		return node{v, cas, n.ds, n.created, n.ver + 1}, keep
 	}
 
 	n.ds = copyMap(n.ds)
-	p, ok := n.ds[parts[0]].set(parts[1:], v, cas, keep)
+	p, ok := n.ds[parts[0]].set(parts[1:], v, cas, seqn, keep)
 	n.ds[parts[0]] = p, ok
 	n.cas = Dir
 	return n, len(n.ds) > 0
 }
 
-func (n node) setp(k, v, cas string, keep bool) node {
+func (n node) setp(k, v, cas string, seqn uint64, keep bool) node {
 	if err := checkPath(k); err != nil {
 		return n
 	}
 
-	n, _ = n.set(split(k), v, cas, keep)
+	n, _ = n.set(split(k), v, cas, seqn, keep)
+	return n
+}
+
+// Returns a copy of `n` with `m` in place of whatever was at `parts`,
+// creating parent directories as necessary.
+func (n node) put(parts []string, m node) node {
+	if len(parts) == 0 {
+		return m
+	}
+
+	n.ds = copyMap(n.ds)
+	n.ds[parts[0]] = n.ds[parts[0]].put(parts[1:], m)
+	n.cas = Dir
 	return n
 }
 
 func (n node) apply(seqn uint64, mut string) (rep node, ev Event) {
 	ev.Seqn, ev.Cas, ev.Mut = seqn, strconv.Uitoa64(seqn), mut
-	if seqn == 1 {
-		d := gob.NewDecoder(strings.NewReader(mut))
-		if d.Decode(&ev.Seqn) == nil {
-			ev.Cas = ""
-			ev.Err = d.Decode(&rep)
-			if ev.Err != nil {
-				ev.Seqn = seqn + 1
-				rep = n
-			}
-			ev.Getter = rep
-			return
+	if seqn == 1 && isAnySnapshot(mut) {
+		ev.Cas = ""
+		ev.Seqn, rep, ev.Err = decodeSnapshot(mut)
+		if ev.Err == nil && ev.Seqn < seqn {
+			// The store can't go backward.
+			ev.Err = ErrBadSnapshot
+		}
+		if ev.Err != nil {
+			ev.Seqn = seqn + 1
+			rep = n
 		}
+		ev.Getter = rep
+		return
 	}
 
 	if mut == Nop {
@@ -114,21 +134,31 @@ func (n node) apply(seqn uint64, mut string) (rep node, ev Event) {
 		return
 	}
 
-	cas, keep := "", false
-	ev.Path, ev.Body, cas, keep, ev.Err = decode(mut)
+	m, err := decodeMut(mut)
+	if err == nil && (m.kind == mutDelTree || m.kind == mutMove) {
+		rep, err = n.applyTree(seqn, m.kind, m.paths, m.cas)
 
-	if ev.Err == nil && keep {
-		components := split(ev.Path)
-		for i := 0; i < len(components)-1; i++ {
-			_, dirCas := n.get(components[0 : i+1])
-			if dirCas == Missing {
-				break
-			}
-			if dirCas != Dir {
-				ev.Err = os.ENOTDIR
-				break
-			}
+		if err == nil {
+			ev.Path, ev.Cas = m.paths[0], Missing
+			ev.Getter = rep
+			return
 		}
+
+		ev.Err = err
+		ev.Path, ev.Body = ErrorPath, err.String()
+		rep = n.setp(ev.Path, ev.Body, ev.Cas, seqn, true)
+		ev.Getter = rep
+		return
+	}
+
+	cas, keep := m.cas, m.kind == mutSet
+	ev.Err = err
+	if err == nil {
+		ev.Path, ev.Body = m.paths[0], m.body
+	}
+
+	if ev.Err == nil && keep {
+		ev.Err = n.checkParents(ev.Path)
 	}
 
 	if ev.Err == nil {
@@ -148,7 +178,7 @@ func (n node) apply(seqn uint64, mut string) (rep node, ev Event) {
 		ev.Cas = Missing
 	}
 
-	rep = n.setp(ev.Path, ev.Body, ev.Cas, keep)
+	rep = n.setp(ev.Path, ev.Body, ev.Cas, seqn, keep)
 	ev.Getter = rep
 	return
 }
@@ -1,9 +1,6 @@
 package store
 
 import (
-	"bytes"
-	"gob"
-	"io"
 	"github.com/bmizerany/assert"
 	"os"
 	"testing"
@@ -14,14 +11,14 @@ func TestNodeApplySet(t *testing.T) {
 	p := "/" + k
 	m := MustEncodeSet(p, v, Clobber)
 	n, e := emptyDir.apply(seqn, m)
-	exp := node{"", Dir, map[string]node{k: {v, cas, nil}}}
+	exp := node{"", Dir, map[string]node{k: {v, cas, nil, 1, 1}}, 0, 0}
 	assert.Equal(t, exp, n)
 	assert.Equal(t, Event{seqn, p, v, cas, m, nil, n}, e)
 }
 
 func TestNodeApplyDel(t *testing.T) {
 	k, seqn, cas := "x", uint64(1), "1"
-	r := node{"", Dir, map[string]node{k: {"a", cas, nil}}}
+	r := node{"", Dir, map[string]node{k: {"a", cas, nil, 1, 1}}, 0, 0}
 	p := "/" + k
 	m := MustEncodeDel(p, cas)
 	n, e := r.apply(seqn, m)
@@ -41,7 +38,7 @@ func TestNodeApplyBadMutation(t *testing.T) {
 	seqn, cas := uint64(1), "1"
 	m := BadMutations[0]
 	n, e := emptyDir.apply(seqn, m)
-	exp := node{"", Dir, map[string]node{"store": {"", Dir, map[string]node{"error": {ErrBadMutation.String(), cas, nil}}}}}
+	exp := node{"", Dir, map[string]node{"store": {"", Dir, map[string]node{"error": {ErrBadMutation.String(), cas, nil, 1, 1}}, 0, 0}}, 0, 0}
 	assert.Equal(t, exp, n)
 	assert.Equal(t, Event{seqn, ErrorPath, ErrBadMutation.String(), cas, m, ErrBadMutation, n}, e)
 }
@@ -51,7 +48,7 @@ func TestNodeApplyBadInstruction(t *testing.T) {
 	m := BadInstructions[0]
 	n, e := emptyDir.apply(seqn, m)
 	err := &BadPathError{""}
-	exp := node{"", Dir, map[string]node{"store": {"", Dir, map[string]node{"error": {err.String(), cas, nil}}}}}
+	exp := node{"", Dir, map[string]node{"store": {"", Dir, map[string]node{"error": {err.String(), cas, nil, 1, 1}}, 0, 0}}, 0, 0}
 	assert.Equal(t, exp, n)
 	assert.Equal(t, Event{seqn, ErrorPath, err.String(), cas, m, err, n}, e)
 }
@@ -61,7 +58,7 @@ func TestNodeApplyCasMismatch(t *testing.T) {
 	p := "/" + k
 	m := MustEncodeSet(p, v, "123")
 	n, e := emptyDir.apply(seqn, m)
-	exp := node{"", Dir, map[string]node{"store": {"", Dir, map[string]node{"error": {ErrCasMismatch.String(), cas, nil}}}}}
+	exp := node{"", Dir, map[string]node{"store": {"", Dir, map[string]node{"error": {ErrCasMismatch.String(), cas, nil, 1, 1}}, 0, 0}}, 0, 0}
 	assert.Equal(t, exp, n)
 	assert.Equal(t, Event{seqn, ErrorPath, ErrCasMismatch.String(), cas, m, ErrCasMismatch, n}, e)
 }
@@ -76,25 +73,18 @@ func TestNodeSnapshotApply(t *testing.T) {
 	_, m := s1.Snapshot()
 
 	n, e := emptyDir.apply(1, m)
-	exp := node{"", Dir, map[string]node{"x": {"b", "2", nil}}}
+	exp := node{"", Dir, map[string]node{"x": {"b", "2", nil, 1, 2}}, 0, 0}
 	assert.Equal(t, exp, n)
 	assert.Equal(t, Event{2, "", "", "", m, nil, n}, e)
 }
 
 func TestNodeSnapshotBad(t *testing.T) {
-	buf := bytes.NewBuffer([]byte{})
-	gob.NewEncoder(buf).Encode(uint64(1))
-	seqnPart := buf.String()
+	_, m := New().Snapshot()
+	m = m[0 : len(m)/2]
 
-	buf = bytes.NewBuffer([]byte{})
-	gob.NewEncoder(buf).Encode(emptyDir)
-	valPart := buf.String()
-	valPart = valPart[0 : len(valPart)/2]
-
-	m := seqnPart + valPart
 	n, e := emptyDir.apply(1, m)
 	assert.Equal(t, emptyDir, n)
-	assert.Equal(t, Event{2, "", "", "", m, io.ErrUnexpectedEOF, n}, e)
+	assert.Equal(t, Event{2, "", "", "", m, ErrBadSnapshot, n}, e)
 }
 
 func TestNodeNotADirectory(t *testing.T) {
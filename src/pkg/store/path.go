@@ -0,0 +1,102 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"unicode"
+	"utf8"
+)
+
+// A path is "/", or one or more components, each preceded by "/".
+//
+// A component is a non-empty UTF-8 string. Some bytes are reserved, and must
+// be written as "%" followed by two uppercase hex digits:
+//
+//  - "/", which separates components
+//  - "=" and ":", which separate the parts of a mutation
+//  - "*" and "?", which have special meaning in a glob
+//  - "%" itself
+//  - spaces and control characters, in any encoding
+//
+// No other byte may be escaped. This way, each name has exactly one written
+// form, and it is the same as its URL encoding. Use Escape and Unescape to
+// convert between names and components.
+
+// Returns true iff the rune `r` must be escaped in a path component.
+func reserved(r int) bool {
+	switch r {
+	case '/', '=', ':', '*', '?', '%', utf8.RuneError:
+		return true
+	}
+	return r < 0x20 || r == 0x7f || unicode.IsSpace(r)
+}
+
+// Returns `name` as a path component, with each reserved byte escaped.
+func Escape(name string) string {
+	b := new(bytes.Buffer)
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if reserved(r) {
+			for _, c := range []byte(name[i : i+size]) {
+				fmt.Fprintf(b, "%%%02X", c)
+			}
+		} else {
+			b.WriteString(name[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// Returns the name written as path component `c`. Does not check that `c` is
+// in canonical form; see checkPath.
+func Unescape(c string) (name string, err os.Error) {
+	b := new(bytes.Buffer)
+	for i := 0; i < len(c); i++ {
+		if c[i] != '%' {
+			b.WriteByte(c[i])
+			continue
+		}
+
+		if i+3 > len(c) {
+			return "", &BadPathError{c}
+		}
+
+		n, err := strconv.Btoui64(c[i+1:i+3], 16)
+		if err != nil {
+			return "", &BadPathError{c}
+		}
+
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func checkComponent(c string) bool {
+	if c == "" {
+		return false
+	}
+
+	name, err := Unescape(c)
+	return err == nil && Escape(name) == c
+}
+
+func checkPath(k string) os.Error {
+	if k == "/" {
+		return nil
+	}
+
+	if len(k) < 2 || k[0] != '/' {
+		return &BadPathError{k}
+	}
+
+	for _, c := range split(k) {
+		if !checkComponent(c) {
+			return &BadPathError{k}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+var escapes = [][]string{
+	{"abc", "abc"},
+	{"a_b-c.d", "a_b-c.d"},
+	{"世界", "世界"},
+	{"a/b", "a%2Fb"},
+	{"a:b=c", "a%3Ab%3Dc"},
+	{"a*?", "a%2A%3F"},
+	{"100%", "100%25"},
+	{"a b", "a%20b"},
+	{"a\u00a0b", "a%C2%A0b"},
+	{"\xff", "%FF"},
+}
+
+func TestEscape(t *testing.T) {
+	for _, e := range escapes {
+		assert.Equal(t, e[1], Escape(e[0]), e[0])
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for _, e := range escapes {
+		name, err := Unescape(e[1])
+		assert.Equal(t, nil, err, e[1])
+		assert.Equal(t, e[0], name, e[1])
+	}
+}
+
+func TestUnescapeBad(t *testing.T) {
+	for _, c := range []string{"%", "%4", "%zz", "a%"} {
+		_, err := Unescape(c)
+		assert.NotEqual(t, nil, err, c)
+	}
+}
+
+func TestEscapedPathsAreGood(t *testing.T) {
+	for _, e := range escapes {
+		assert.Equal(t, nil, checkPath("/"+e[1]), e[1])
+	}
+}
+
+func TestEscapedMutation(t *testing.T) {
+	p := "/" + Escape("a:b=c")
+	m := MustEncodeSet(p, "x=y:z", Clobber)
+	path, v, cas, keep, err := decode(m)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, p, path)
+	assert.Equal(t, "x=y:z", v)
+	assert.Equal(t, Clobber, cas)
+	assert.T(t, keep)
+}
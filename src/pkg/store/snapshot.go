@@ -0,0 +1,277 @@
+package store
+
+import (
+	"bytes"
+	"doozer/util"
+	"fmt"
+	"gob"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A snapshot mutation is self-describing, so that the encoding of the tree
+// can change without a node misinterpreting a format it doesn't know:
+//
+//   snap:<version>:<crc32 of payload, in hex>:<payload>
+//
+// A node that sees an unknown version or a bad checksum rejects the snapshot
+// with ErrBadSnapshot. See doc/snapshot.md for the payload formats.
+const (
+	snapPrefix  = "snap:"
+	snapVersion = 3
+)
+
+// Returns true iff `mut` looks like a snapshot mutation. It does not check
+// that the snapshot is well formed; use CheckSnapshot for that.
+func IsSnapshot(mut string) bool {
+	return strings.HasPrefix(mut, snapPrefix)
+}
+
+// Before snapshots had a header, a snapshot was a gob-encoded seqn followed
+// by the gob-encoded tree. Nodes of that vintage still send and expect one
+// when joining, so it is accepted at seqn 1 too. With no header to go by, it
+// is told apart from other mutations by decoding it.
+func isLegacySnapshot(mut string) bool {
+	_, _, err := decodeGob(mut)
+	return err == nil
+}
+
+func isAnySnapshot(mut string) bool {
+	return IsSnapshot(mut) || isLegacySnapshot(mut)
+}
+
+// Checks the version and checksum of snapshot mutation `mut` without decoding
+// its payload. A legacy snapshot, having neither, is decoded instead.
+func CheckSnapshot(mut string) os.Error {
+	if !IsSnapshot(mut) {
+		if !isLegacySnapshot(mut) {
+			return ErrBadSnapshot
+		}
+		return nil
+	}
+
+	_, _, err := splitSnapshot(mut)
+	return err
+}
+
+// Decodes snapshot mutation `mut`, as produced by Store.Snapshot or
+// Store.LegacySnapshot, without applying it to a store. This is useful for
+// inspecting backups.
+func DecodeSnapshot(mut string) (seqn uint64, g Getter, err os.Error) {
+	seqn, root, err := decodeSnapshot(mut)
+	if err != nil {
+		return 0, nil, err
+	}
+	return seqn, root, nil
+}
+
+func encodeSnapshot(ver uint64, root node) string {
+	w := new(bytes.Buffer)
+
+	writeUint64(w, ver)
+	writeRecords(w, "/", root)
+
+	payload := w.String()
+	sum := crc32.ChecksumIEEE([]byte(payload))
+	return fmt.Sprintf("%s%d:%08x:%s", snapPrefix, snapVersion, sum, payload)
+}
+
+func encodeLegacySnapshot(ver uint64, root node) string {
+	w := new(bytes.Buffer)
+
+	err := gob.NewEncoder(w).Encode(ver)
+	if err != nil {
+		panic(err)
+	}
+
+	err = gob.NewEncoder(w).Encode(root)
+	if err != nil {
+		panic(err)
+	}
+
+	return w.String()
+}
+
+// Writes a record for each file under `n`, in lexical order.
+func writeRecords(w *bytes.Buffer, path string, n node) {
+	if n.cas != Dir {
+		writeField(w, path)
+		writeField(w, n.v)
+		writeField(w, n.cas)
+		writeUint64(w, n.created)
+		writeUint64(w, uint64(n.ver))
+		return
+	}
+
+	if path == "/" {
+		path = ""
+	}
+
+	names := n.readdir()
+	sort.SortStrings(names)
+	for _, name := range names {
+		writeRecords(w, path+"/"+name, n.ds[name])
+	}
+}
+
+func writeField(w *bytes.Buffer, s string) {
+	b := make([]byte, 4)
+	util.Packui64(b, uint64(len(s)))
+	w.Write(b)
+	w.WriteString(s)
+}
+
+func writeUint64(w *bytes.Buffer, n uint64) {
+	b := make([]byte, 8)
+	util.Packui64(b, n)
+	w.Write(b)
+}
+
+func readUint64(s string) (n uint64, rest string, err os.Error) {
+	if len(s) < 8 {
+		return 0, "", ErrBadSnapshot
+	}
+
+	return util.Unpackui64([]byte(s[0:8])), s[8:], nil
+}
+
+func readField(s string) (field, rest string, err os.Error) {
+	if len(s) < 4 {
+		return "", "", ErrBadSnapshot
+	}
+
+	n := util.Unpackui64([]byte(s[0:4]))
+	s = s[4:]
+	if uint64(len(s)) < n {
+		return "", "", ErrBadSnapshot
+	}
+
+	return s[0:n], s[n:], nil
+}
+
+func splitSnapshot(mut string) (version int, payload string, err os.Error) {
+	if !IsSnapshot(mut) {
+		return 0, "", ErrBadSnapshot
+	}
+
+	parts := strings.Split(mut[len(snapPrefix):], ":", 3)
+	if len(parts) != 3 {
+		return 0, "", ErrBadSnapshot
+	}
+
+	version, err = strconv.Atoi(parts[0])
+	if err != nil || version != snapVersion {
+		return 0, "", ErrBadSnapshot
+	}
+
+	sum, err := strconv.Btoui64(parts[1], 16)
+	if err != nil || uint32(sum) != crc32.ChecksumIEEE([]byte(parts[2])) {
+		return 0, "", ErrBadSnapshot
+	}
+
+	return version, parts[2], nil
+}
+
+func decodeSnapshot(mut string) (seqn uint64, root node, err os.Error) {
+	if !IsSnapshot(mut) {
+		seqn, root, err = decodeGob(mut)
+		if err != nil {
+			return 0, node{}, ErrBadSnapshot
+		}
+		return seqn, root, nil
+	}
+
+	_, payload, err := splitSnapshot(mut)
+	if err != nil {
+		return 0, node{}, err
+	}
+
+	return decodeRecords(payload)
+}
+
+func decodeRecords(payload string) (seqn uint64, root node, err os.Error) {
+	seqn, payload, err = readUint64(payload)
+	if err != nil {
+		return 0, node{}, err
+	}
+
+	root = emptyDir
+	for len(payload) > 0 {
+		var path string
+		var n node
+
+		path, payload, err = readField(payload)
+		if err != nil {
+			return 0, node{}, err
+		}
+
+		n.v, payload, err = readField(payload)
+		if err != nil {
+			return 0, node{}, err
+		}
+
+		n.cas, payload, err = readField(payload)
+		if err != nil {
+			return 0, node{}, err
+		}
+
+		if checkPath(path) != nil || n.cas == Dir || n.cas == Missing {
+			return 0, node{}, ErrBadSnapshot
+		}
+
+		var ver uint64
+		n.created, payload, err = readUint64(payload)
+		if err != nil {
+			return 0, node{}, err
+		}
+
+		ver, payload, err = readUint64(payload)
+		if err != nil {
+			return 0, node{}, err
+		}
+		n.ver = int(ver)
+
+		root = root.put(split(path), n)
+	}
+
+	return seqn, root, nil
+}
+
+// Decodes a legacy snapshot. See isLegacySnapshot.
+func decodeGob(payload string) (seqn uint64, root node, err os.Error) {
+	d := gob.NewDecoder(strings.NewReader(payload))
+
+	err = d.Decode(&seqn)
+	if err != nil {
+		return 0, node{}, err
+	}
+
+	err = d.Decode(&root)
+	if err != nil {
+		return 0, node{}, err
+	}
+
+	return seqn, guessStat(root), nil
+}
+
+// Files from a node that did not record metadata get a reasonable guess:
+// created when last set, and set once.
+func guessStat(n node) node {
+	if n.cas != Dir {
+		if n.created == 0 {
+			n.created, _ = strconv.Atoui64(n.cas)
+			n.ver = 1
+		}
+		return n
+	}
+
+	ds := make(map[string]node, len(n.ds))
+	for name, m := range n.ds {
+		ds[name] = guessStat(m)
+	}
+	n.ds = ds
+	return n
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"gob"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotHasHeader(t *testing.T) {
+	_, snap := New().Snapshot()
+	assert.T(t, IsSnapshot(snap))
+	assert.T(t, strings.HasPrefix(snap, "snap:3:"), snap[0:7])
+	assert.Equal(t, nil, CheckSnapshot(snap))
+}
+
+func TestSnapshotNotSnapshot(t *testing.T) {
+	assert.Equal(t, false, IsSnapshot(MustEncodeSet("/x", "a", Clobber)))
+	assert.Equal(t, false, IsSnapshot(Nop))
+	assert.Equal(t, ErrBadSnapshot, CheckSnapshot(Nop))
+}
+
+func TestSnapshotBadChecksum(t *testing.T) {
+	_, snap := New().Snapshot()
+	snap = snap + "x"
+	assert.Equal(t, ErrBadSnapshot, CheckSnapshot(snap))
+}
+
+func TestSnapshotUnknownVersion(t *testing.T) {
+	_, snap := New().Snapshot()
+	snap = "snap:999" + snap[len("snap:3"):]
+	assert.Equal(t, ErrBadSnapshot, CheckSnapshot(snap))
+}
+
+func TestSnapshotDecode(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/x", "a", Clobber))
+	snap := encodeSnapshot(7, r)
+
+	seqn, n, err := decodeSnapshot(snap)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(7), seqn)
+	assert.Equal(t, r, n)
+}
+
+func TestSnapshotOnlyAtOne(t *testing.T) {
+	_, snap := New().Snapshot()
+	n, e := emptyDir.apply(2, snap)
+	assert.NotEqual(t, nil, e.Err)
+	assert.Equal(t, ErrorPath, e.Path)
+	assert.NotEqual(t, emptyDir, n)
+}
+
+func TestSnapshotRecords(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/y/z", "b=c:d", Clobber))
+	r, _ = r.apply(3, MustEncodeSet("/a", "", Clobber))
+	snap := encodeSnapshot(3, r)
+
+	seqn, n, err := decodeSnapshot(snap)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(3), seqn)
+	assert.Equal(t, r, n)
+}
+
+func TestSnapshotRecordsRootFile(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/", "a", Clobber))
+	_, n, err := decodeSnapshot(encodeSnapshot(1, r))
+	assert.Equal(t, nil, err)
+	v, cas := n.Get("/")
+	assert.Equal(t, []string{"a"}, v)
+	assert.Equal(t, "1", cas)
+}
+
+func TestSnapshotRecordsSorted(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/b", "", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/a", "", Clobber))
+	snap := encodeSnapshot(2, r)
+	assert.T(t, strings.Index(snap, "/a") < strings.Index(snap, "/b"))
+}
+
+func TestSnapshotRecordsTruncated(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/x", "a", Clobber))
+	w := new(bytes.Buffer)
+	writeRecords(w, "/", r)
+	payload := w.String()[0 : w.Len()-1]
+
+	_, _, err := decodeRecords(string(make([]byte, 8)) + payload)
+	assert.Equal(t, ErrBadSnapshot, err)
+}
+
+func TestSnapshotOldVersions(t *testing.T) {
+	w := new(bytes.Buffer)
+	writeUint64(w, 4)
+	payload := w.String()
+	sum := crc32.ChecksumIEEE([]byte(payload))
+
+	for _, v := range []int{1, 2} {
+		snap := fmt.Sprintf("snap:%d:%08x:%s", v, sum, payload)
+		assert.Equal(t, ErrBadSnapshot, CheckSnapshot(snap))
+	}
+}
+
+func TestSnapshotKeepsStat(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/x", "bb", Clobber))
+	_, n, err := decodeSnapshot(encodeSnapshot(2, r))
+	assert.Equal(t, nil, err)
+	st, _ := n.Stat("/x")
+	assert.Equal(t, Stat{Created: 1, Modified: 2, Version: 2, Len: 2}, st)
+}
+
+func TestSnapshotDecodeGetter(t *testing.T) {
+	s1 := New()
+	s1.Ops <- Op{1, MustEncodeSet("/x", "a", Clobber)}
+	s1.Sync(1)
+
+	_, snap := s1.Snapshot()
+	seqn, g, err := DecodeSnapshot(snap)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), seqn)
+	assert.Equal(t, "a", GetString(g, "/x"))
+}
+
+func TestSnapshotSeqnZero(t *testing.T) {
+	_, snap := New().Snapshot()
+	n, e := emptyDir.apply(1, snap)
+	assert.Equal(t, emptyDir, n)
+	assert.Equal(t, ErrBadSnapshot, e.Err)
+}
+
+func TestLegacySnapshot(t *testing.T) {
+	s1 := New()
+	s1.Ops <- Op{1, MustEncodeSet("/x", "a", Clobber)}
+	s1.Ops <- Op{2, MustEncodeSet("/x", "b", Clobber)}
+	s1.Sync(2)
+
+	seqn, snap := s1.LegacySnapshot()
+	assert.Equal(t, uint64(2), seqn)
+	assert.T(t, !IsSnapshot(snap))
+	assert.Equal(t, nil, CheckSnapshot(snap))
+
+	s2 := New()
+	s2.Ops <- Op{1, snap}
+	s2.Sync(2)
+	st, cas := s2.Stat("/x")
+	assert.Equal(t, "2", cas)
+	assert.Equal(t, Stat{Created: 1, Modified: 2, Version: 2, Len: 1}, st)
+}
+
+// The tree as nodes without metadata encoded it.
+type legacyNode struct {
+	v   string
+	cas string
+	ds  map[string]legacyNode
+}
+
+func TestLegacySnapshotGuessesStat(t *testing.T) {
+	root := legacyNode{cas: Dir, ds: map[string]legacyNode{
+		"x": {v: "a", cas: "3"},
+	}}
+	w := new(bytes.Buffer)
+	gob.NewEncoder(w).Encode(uint64(4))
+	gob.NewEncoder(w).Encode(root)
+
+	seqn, n, err := decodeSnapshot(w.String())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(4), seqn)
+	st, cas := n.Stat("/x")
+	assert.Equal(t, "3", cas)
+	assert.Equal(t, Stat{Created: 3, Modified: 3, Version: 1, Len: 1}, st)
+}
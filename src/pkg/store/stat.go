@@ -0,0 +1,92 @@
+package store
+
+import (
+	"strconv"
+)
+
+// Metadata about a file or directory in the store.
+//
+// For a file, Created is the seqn at which it was created, Modified is the
+// seqn at which it was last set (this is the same as its CAS token), Version
+// is the number of times it has been set since it was created, and Len is the
+// length of its body.
+//
+// For a directory, Modified is the Modified seqn of its newest descendant,
+// and Children is its number of entries. Directories exist only implicitly,
+// so Created and Version are always 0.
+type Stat struct {
+	Created  uint64
+	Modified uint64
+	Version  int
+	Len      int
+	Children int
+}
+
+// Returns metadata for the file or directory at `path`, along with its CAS
+// token, which is `Dir` for a directory. If there is no such path, `cas` is
+// `Missing` and `s` is zero.
+func (n node) Stat(path string) (s Stat, cas string) {
+	if err := checkPath(path); err != nil {
+		return Stat{}, Missing
+	}
+
+	m, ok := n.lookup(split(path))
+	if !ok {
+		return Stat{}, Missing
+	}
+
+	return m.stat(), m.cas
+}
+
+func (n node) lookup(parts []string) (node, bool) {
+	for _, part := range parts {
+		m, ok := n.ds[part]
+		if !ok {
+			return node{}, false
+		}
+		n = m
+	}
+	return n, true
+}
+
+func (n node) stat() Stat {
+	if n.cas == Dir {
+		return Stat{Modified: n.newest(), Children: len(n.ds)}
+	}
+
+	return Stat{
+		Created:  n.created,
+		Modified: n.modified(),
+		Version:  n.ver,
+		Len:      len(n.v),
+	}
+}
+
+func (n node) modified() uint64 {
+	seqn, _ := strconv.Atoui64(n.cas)
+	return seqn
+}
+
+// Returns the largest Modified seqn of any file under `n`.
+func (n node) newest() (seqn uint64) {
+	if n.cas != Dir {
+		return n.modified()
+	}
+
+	for _, m := range n.ds {
+		if s := m.newest(); s > seqn {
+			seqn = s
+		}
+	}
+	return seqn
+}
+
+// Returns metadata for `path` as of the current state of the store. See
+// Stat for details.
+func (st *Store) Stat(path string) (s Stat, cas string) {
+	// WARNING: Be sure to read the pointer value of st.state only once. If you
+	// need multiple accesses, copy the pointer first.
+	p := st.state
+
+	return p.root.Stat(path)
+}
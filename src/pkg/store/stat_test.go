@@ -0,0 +1,52 @@
+package store
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestStatMissing(t *testing.T) {
+	s, cas := emptyDir.Stat("/x")
+	assert.Equal(t, Missing, cas)
+	assert.Equal(t, Stat{}, s)
+}
+
+func TestStatBadPath(t *testing.T) {
+	_, cas := emptyDir.Stat("x")
+	assert.Equal(t, Missing, cas)
+}
+
+func TestStatFile(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/y", "b", Clobber))
+	r, _ = r.apply(3, MustEncodeSet("/x", "abc", Clobber))
+	s, cas := r.Stat("/x")
+	assert.Equal(t, "3", cas)
+	assert.Equal(t, Stat{Created: 1, Modified: 3, Version: 2, Len: 3}, s)
+}
+
+func TestStatRecreated(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeDel("/x", Clobber))
+	r, _ = r.apply(3, MustEncodeSet("/x", "a", Clobber))
+	s, _ := r.Stat("/x")
+	assert.Equal(t, Stat{Created: 3, Modified: 3, Version: 1, Len: 1}, s)
+}
+
+func TestStatDir(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/d/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/d/e/y", "b", Clobber))
+	r, _ = r.apply(3, MustEncodeSet("/z", "c", Clobber))
+	s, cas := r.Stat("/d")
+	assert.Equal(t, Dir, cas)
+	assert.Equal(t, Stat{Modified: 2, Children: 2}, s)
+}
+
+func TestStoreStat(t *testing.T) {
+	st := New()
+	st.Ops <- Op{1, MustEncodeSet("/x", "a", Clobber)}
+	st.Sync(1)
+	s, cas := st.Stat("/x")
+	assert.Equal(t, "1", cas)
+	assert.Equal(t, Stat{Created: 1, Modified: 1, Version: 1, Len: 1}, s)
+}
@@ -1,11 +1,8 @@
 package store
 
 import (
-	"bytes"
-	"gob"
 	"doozer/util"
 	"os"
-	"regexp"
 	"strings"
 )
 
@@ -15,15 +12,6 @@ const (
 	Dir     = "dir"
 )
 
-// TODO revisit this when package regexp is more complete (e.g. do Unicode)
-const (
-	charPat = `([a-zA-Z0-9.]|-)`
-	partPat = "/" + charPat + "+"
-	pathPat = "^/$|^(" + partPat + ")+$"
-)
-
-var pathRe = regexp.MustCompile(pathPat)
-
 var (
 	ErrBadMutation = os.NewError("bad mutation")
 	ErrBadSnapshot = os.NewError("bad snapshot")
@@ -47,12 +35,15 @@ type Store struct {
 	Seqns   <-chan uint64
 	Watches <-chan int
 	watchCh chan watch
-	watches []watch
+	watches *watchIndex
 	todo    map[uint64]Op
 	state   *state
 	log     map[uint64]Event
 	cleanCh chan uint64
 	notices []notice
+	hist    map[string][]Version
+	retain  map[string]retention
+	histCh  chan histReq
 }
 
 // Represents an operation to apply to the store at position Seqn.
@@ -72,7 +63,7 @@ type state struct {
 
 type watch struct {
 	out chan Event
-	re  *regexp.Regexp
+	f   *filter
 }
 
 type notice struct {
@@ -94,11 +85,14 @@ func New() *Store {
 		Watches: watches,
 		watchCh: make(chan watch),
 		todo:    make(map[uint64]Op),
-		watches: []watch{},
+		watches: newWatchIndex(),
 		state:   &state{0, emptyDir},
 		log:     make(map[uint64]Event),
 		cleanCh: make(chan uint64),
 		notices: make([]notice, 1),
+		hist:    make(map[string][]Version),
+		retain:  make(map[string]retention),
+		histCh:  make(chan histReq),
 	}
 
 	go st.process(ops, seqns, watches)
@@ -116,23 +110,16 @@ func join(parts []string) string {
 	return "/" + strings.Join(parts, "/")
 }
 
-func checkPath(k string) os.Error {
-	if !pathRe.MatchString(k) {
-		return &BadPathError{k}
-	}
-	return nil
-}
-
 // Returns a mutation that can be applied to a `Store`. The mutation will set
 // the contents of the file at `path` to `body` iff the CAS token of that file
 // matches `cas` at the time of application.
 //
 // If `path` is not valid, returns a `BadPathError`.
-func EncodeSet(path, body string, cas string) (mutation string, err os.Error) {
+func EncodeSet(path, body string, cas string) (mut string, err os.Error) {
 	if err = checkPath(path); err != nil {
 		return
 	}
-	return cas + ":" + path + "=" + body, nil
+	return encodeText(mutation{kind: mutSet, cas: cas, paths: []string{path}, body: body}), nil
 }
 
 // Returns a mutation that can be applied to a `Store`. The mutation will cause
@@ -140,17 +127,17 @@ func EncodeSet(path, body string, cas string) (mutation string, err os.Error) {
 // `cas` at the time of application.
 //
 // If `path` is not valid, returns a `BadPathError`.
-func EncodeDel(path string, cas string) (mutation string, err os.Error) {
-	if err := checkPath(path); err != nil {
+func EncodeDel(path string, cas string) (mut string, err os.Error) {
+	if err = checkPath(path); err != nil {
 		return
 	}
-	return cas + ":" + path, nil
+	return encodeText(mutation{kind: mutDel, cas: cas, paths: []string{path}}), nil
 }
 
 // MustEncodeSet is like EncodeSet but panics if the mutation cannot be
 // encoded. It simplifies safe initialization of global variables holding
 // mutations.
-func MustEncodeSet(path, body, cas string) (mutation string) {
+func MustEncodeSet(path, body, cas string) (mut string) {
 	m, err := EncodeSet(path, body, cas)
 	if err != nil {
 		panic(err)
@@ -161,7 +148,7 @@ func MustEncodeSet(path, body, cas string) (mutation string) {
 // MustEncodeDel is like EncodeDel but panics if the mutation cannot be
 // encoded. It simplifies safe initialization of global variables holding
 // mutations.
-func MustEncodeDel(path, cas string) (mutation string) {
+func MustEncodeDel(path, cas string) (mut string) {
 	m, err := EncodeDel(path, cas)
 	if err != nil {
 		panic(err)
@@ -169,8 +156,9 @@ func MustEncodeDel(path, cas string) (mutation string) {
 	return m
 }
 
-func decode(mutation string) (path, v, cas string, keep bool, err os.Error) {
-	cm := strings.Split(mutation, ":", 2)
+// Decodes a set or del mutation in the text format.
+func decode(mut string) (path, v, cas string, keep bool, err os.Error) {
+	cm := strings.Split(mut, ":", 2)
 
 	if len(cm) != 2 {
 		err = ErrBadMutation
@@ -193,18 +181,8 @@ func decode(mutation string) (path, v, cas string, keep bool, err os.Error) {
 }
 
 func (st *Store) notify(e Event) {
-	nwatches := make([]watch, len(st.watches))
-
-	i := 0
-	for _, w := range st.watches {
-		if closed(w.out) {
-			continue
-		}
-
-		nwatches[i] = w
-		i++
-
-		if w.re.MatchString(e.Path) {
+	for _, w := range st.watches.lookup(e.Path) {
+		if w.f.match(e) {
 			st.notices = append(st.notices, notice{w.out, e})
 
 			if st.notices[0].ch == nil {
@@ -212,14 +190,12 @@ func (st *Store) notify(e Event) {
 			}
 		}
 	}
-
-	st.watches = nwatches[0:i]
 }
 
 func (st *Store) closeWatches() {
-	for _, w := range st.watches {
+	st.watches.each(func(w *watch) {
 		close(w.out)
-	}
+	})
 }
 
 func (st *Store) process(ops <-chan Op, seqns chan<- uint64, watches chan<- int) {
@@ -242,14 +218,18 @@ func (st *Store) process(ops <-chan Op, seqns chan<- uint64, watches chan<- int)
 				st.todo[a.Seqn] = a
 			}
 		case w := <-st.watchCh:
-			st.watches = append(st.watches, w)
+			st.watches.add(&w)
 		case seqn := <-st.cleanCh:
 			for ; head <= seqn; head++ {
 				st.log[head] = Event{}, false
 			}
+		case r := <-st.histCh:
+			vs := make([]Version, len(st.hist[r.path]))
+			copy(vs, st.hist[r.path])
+			r.ch <- vs
 		case seqns <- ver:
 			// nothing to do here
-		case watches <- len(st.watches):
+		case watches <- st.watches.n:
 			// nothing to do here
 		case st.notices[0].ch <- st.notices[0].ev:
 			st.notices = st.notices[1:]
@@ -262,11 +242,18 @@ func (st *Store) process(ops <-chan Op, seqns chan<- uint64, watches chan<- int)
 		// If we have any mutations that can be applied, do them.
 		for t, ok := st.todo[ver+1]; ok; t, ok = st.todo[ver+1] {
 			var ev Event
+			before := values
 			values, ev = values.apply(t.Seqn, t.Mut)
-			logger.Printf("apply %s %v %v %v %v %v", ev.Desc(), ev.Seqn, ev.Path, ev.Body, ev.Cas, ev.Err)
+			logger.Debug("apply", "kind", ev.Desc(), "seqn", ev.Seqn, "path", ev.Path, "body", ev.Body, "cas", ev.Cas, "err", ev.Err)
 			st.state = &state{ev.Seqn, values}
 			st.log[t.Seqn] = ev
-			st.notify(ev)
+			if t.Seqn == 1 && isAnySnapshot(t.Mut) && ev.Err == nil {
+				st.reconfigure(values)
+			}
+			for _, e := range fanout(before, ev) {
+				st.record(e)
+				st.notify(e)
+			}
 			for ver < ev.Seqn {
 				ver++
 				st.todo[ver] = Op{}, false
@@ -306,25 +293,23 @@ func (st *Store) Get(path string) (value []string, cas string) {
 // A snapshot must be applied at sequence number 1. Once a snapshot has been
 // applied, the store's sequence number will be set to `seqn`.
 //
+// The mutation carries a format version and a checksum of its contents. See
+// CheckSnapshot.
+//
 // Note that applying a snapshot does not send notifications.
 func (st *Store) Snapshot() (seqn uint64, mutation string) {
-	w := new(bytes.Buffer)
-
 	// WARNING: Be sure to read the pointer value of st.state only once. If you
 	// need multiple accesses, copy the pointer first.
 	ss := st.state
 
-	err := gob.NewEncoder(w).Encode(ss.ver)
-	if err != nil {
-		panic(err)
-	}
-
-	err = gob.NewEncoder(w).Encode(ss.root)
-	if err != nil {
-		panic(err)
-	}
+	return ss.ver, encodeSnapshot(ss.ver, ss.root)
+}
 
-	return ss.ver, w.String()
+// Like Snapshot, but in the legacy form, without a header, that nodes from
+// before snapshots had one can read. See doc/snapshot.md.
+func (st *Store) LegacySnapshot() (seqn uint64, mutation string) {
+	ss := st.state
+	return ss.ver, encodeLegacySnapshot(ss.ver, ss.root)
 }
 
 // Returns a channel that will receive notifications when mutations are applied
@@ -339,15 +324,48 @@ func (st *Store) Snapshot() (seqn uint64, mutation string) {
 //
 // Notifications will not be sent for changes made as the result of applying a
 // snapshot.
-func (st *Store) Watch(pattern string) <-chan Event {
+//
+// If `pattern` is not a valid glob, returns a `BadPatternError`.
+func (st *Store) Watch(pattern string) (<-chan Event, os.Error) {
 	ch := make(chan Event)
-	st.watchOn(pattern, ch)
+	err := st.watchOn(pattern, ch)
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Like Watch, but panics if `pattern` is not a valid glob. For use with
+// patterns fixed in the program, never with ones from a client.
+func (st *Store) MustWatch(pattern string) <-chan Event {
+	ch, err := st.Watch(pattern)
+	if err != nil {
+		panic(err)
+	}
 	return ch
 }
 
-func (st *Store) watchOn(pattern string, ch chan Event) {
-	re, _ := compileGlob(pattern)
-	st.watchCh <- watch{out: ch, re: re}
+// Like Watch, but sends only the events selected by `f`.
+//
+// If any pattern in `f` is not valid, returns a `BadPatternError`.
+func (st *Store) WatchFilter(f Filter) (<-chan Event, os.Error) {
+	cf, err := f.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event)
+	st.watchCh <- watch{out: ch, f: cf}
+	return ch, nil
+}
+
+func (st *Store) watchOn(pattern string, ch chan Event) os.Error {
+	f, err := Filter{Globs: []string{pattern}}.compile()
+	if err != nil {
+		return err
+	}
+	st.watchCh <- watch{out: ch, f: f}
+	return nil
 }
 
 // Returns a read-only chan that will receive a single event representing the
@@ -356,7 +374,7 @@ func (st *Store) watchOn(pattern string, ch chan Event) {
 // If `seqn` was applied before the call to `Wait`, a dummy event will be
 // sent with its `Err` set to `ErrTooLate`.
 func (st *Store) Wait(seqn uint64) <-chan Event {
-	ch, all := make(chan Event, 1), st.Watch("**")
+	ch, all := make(chan Event, 1), st.MustWatch("**")
 
 	// Reading shared state. This must happen after the call to st.Watch.
 	if <-st.Seqns >= seqn {
@@ -390,8 +408,15 @@ func (st *Store) Sync(seqn uint64) {
 
 // Returns an immutable copy of `st` in which `path` exists as a regular file
 // (not a dir). Waits for `path` to be set, if necessary.
-func (st *Store) SyncPath(path string) Getter {
-	evs := st.Watch(path)
+//
+// If `path` is not a valid path, returns a `BadPathError`.
+func (st *Store) SyncPath(path string) (Getter, os.Error) {
+	err := checkPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	evs := st.MustWatch(path)
 	defer func() {
 		close(evs)
 		<-evs
@@ -400,12 +425,12 @@ func (st *Store) SyncPath(path string) Getter {
 	g := st.state.root // TODO make this use a public method
 	_, cas := g.Get(path)
 	if cas != Dir && cas != Missing {
-		return g
+		return g, nil
 	}
 
 	for ev := range evs {
 		if ev.IsSet() {
-			return ev
+			return ev, nil
 		}
 	}
 
@@ -418,17 +443,32 @@ func (st *Store) SyncPath(path string) Getter {
 // The subscription is made before listing the directory entries. This
 // guarantees no entry will be missed, but one or more of the dummy events may
 // duplicate a true event.
-func (st *Store) GetDirAndWatch(path string, ch chan Event) {
-	st.watchOn(path+"/*", ch)
+//
+// If `path` is not valid, returns a `BadPathError`.
+func (st *Store) GetDirAndWatch(path string, ch chan Event) os.Error {
+	if err := checkPath(path); err != nil {
+		return err
+	}
+
+	prefix := path + "/"
+	if path == "/" {
+		prefix = "/"
+	}
+
+	err := st.watchOn(prefix+"*", ch)
+	if err != nil {
+		return err
+	}
 	go func() {
 		for _, ent := range GetDir(st, path) {
-			p := path + "/" + ent
+			p := prefix + ent
 			v, cas := st.Get(p)
 			if cas != Missing && cas != Dir {
 				ch <- Event{0, p, v[0], cas, "", nil, nil}
 			}
 		}
 	}()
+	return nil
 }
 
 func (st *Store) Clean(seqn uint64) {
@@ -2,8 +2,6 @@ package store
 
 import (
 	"github.com/bmizerany/assert"
-	"bytes"
-	"gob"
 	"sort"
 	"strconv"
 	"testing"
@@ -32,6 +30,13 @@ var GoodPaths = []string{
 	"/x/y-z",
 	"/x/y.z",
 	"/x/0",
+	"/x/y_z",
+	"/x/世界",
+	"/x/a%3Ab",
+	"/x/a%2Fb",
+	"/x/a%20b",
+	"/x/%25",
+	"/x/a+b",
 }
 
 var BadPaths = []string{
@@ -41,6 +46,15 @@ var BadPaths = []string{
 	"/x y",
 	"/x/",
 	"/x//y",
+	"/x:y",
+	"/x*",
+	"/x?",
+	"/x%",
+	"/x%3",
+	"/x%3a",
+	"/x%41",
+	"/x\ty",
+	"/x\xffy",
 }
 
 var BadInstructions = []string{
@@ -99,6 +113,10 @@ func TestEncodeSet(t *testing.T) {
 		got, err := EncodeSet(k, v, c)
 		assert.Equal(t, nil, err)
 		assert.Equal(t, exp, got)
+
+		m, err := decodeMut(got)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, mutation{mutSet, c, []string{k}, v}, m)
 	}
 }
 
@@ -114,9 +132,18 @@ func TestEncodeDel(t *testing.T) {
 		got, err := EncodeDel(k, c)
 		assert.Equal(t, nil, err)
 		assert.Equal(t, exp, got)
+
+		m, err := decodeMut(got)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, mutation{mutDel, c, []string{k}, ""}, m)
 	}
 }
 
+func TestEncodeDelBadPath(t *testing.T) {
+	_, err := EncodeDel("x", Clobber)
+	assert.NotEqual(t, nil, err)
+}
+
 func BenchmarkEncodeDel(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		EncodeDel("/x", Clobber)
@@ -223,6 +250,44 @@ func BenchmarkApply(b *testing.B) {
 	}
 }
 
+// Registers `n` watches, each on its own service directory, as many
+// service-discovery clients would.
+func benchWatches(n int, add func(*watch)) {
+	for i := 0; i < n; i++ {
+		f := mustFilter(Filter{Globs: []string{"/svc/" + strconv.Itoa(i) + "/*"}})
+		add(&watch{out: make(chan Event), f: f})
+	}
+}
+
+func BenchmarkNotifyIndexed(b *testing.B) {
+	b.StopTimer()
+	st := &Store{watches: newWatchIndex(), notices: make([]notice, 1)}
+	benchWatches(10000, func(w *watch) { st.watches.add(w) })
+	ev := Event{Seqn: 1, Path: "/svc/5000/x", Cas: "1"}
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		st.notify(ev)
+		st.notices = st.notices[0:1]
+	}
+}
+
+// For comparison with BenchmarkNotifyIndexed: matches the event against
+// every watch, as notify did before watches were indexed.
+func BenchmarkNotifyScan(b *testing.B) {
+	b.StopTimer()
+	var ws []*watch
+	benchWatches(10000, func(w *watch) { ws = append(ws, w) })
+	ev := Event{Seqn: 1, Path: "/svc/5000/x", Cas: "1"}
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, w := range ws {
+			w.f.match(ev)
+		}
+	}
+}
+
 func TestGetSync(t *testing.T) {
 	chV := make(chan []string)
 	chCas := make(chan string)
@@ -448,7 +513,7 @@ func watchOn(st *Store, path string, out chan<- Event) {
 			out <- e
 		}
 		close(out)
-	}(st.Watch(path))
+	}(st.MustWatch(path))
 }
 
 func TestWatchSetSimple(t *testing.T) {
@@ -647,7 +712,7 @@ func TestWatchApply(t *testing.T) {
 func TestWatchClose(t *testing.T) {
 	st := New()
 
-	ch := st.Watch("/x")
+	ch := st.MustWatch("/x")
 
 	st.Ops <- Op{1, MustEncodeSet("/x", "", Clobber)}
 	<-ch // Read the first event
@@ -699,8 +764,7 @@ func TestSyncPathClose(t *testing.T) {
 
 	for {
 		st.Ops <- Op{0, ""} // just for synchronization
-		x := st.watches
-		if len(x) > 0 {
+		if <-st.Watches > 0 {
 			break
 		}
 	}
@@ -736,17 +800,11 @@ func TestSnapshotApply(t *testing.T) {
 }
 
 func TestSnapshotBad(t *testing.T) {
-	buf := bytes.NewBuffer([]byte{})
-	gob.NewEncoder(buf).Encode(uint64(1))
-	seqnPart := buf.String()
-
-	buf = bytes.NewBuffer([]byte{})
-	gob.NewEncoder(buf).Encode(emptyDir)
-	valPart := buf.String()
-	valPart = valPart[0 : len(valPart)/2]
+	_, snap := New().Snapshot()
+	snap = snap[0 : len(snap)/2]
 
 	st := New()
-	st.Ops <- Op{1, seqnPart + valPart}
+	st.Ops <- Op{1, snap}
 	st.Sync(1)
 
 	// check that we aren't leaking memory
@@ -1017,7 +1075,8 @@ func TestSyncPathFuture(t *testing.T) {
 		st.Ops <- Op{4, MustEncodeSet("/z", "d", "")}
 	}()
 
-	g := st.SyncPath("/y")
+	g, err := st.SyncPath("/y")
+	assert.Equal(t, nil, err)
 	got := GetString(g, "/y")
 
 	// TODO: FIX RACE
@@ -1035,18 +1094,35 @@ func TestSyncPathImmediate(t *testing.T) {
 	st.Ops <- Op{1, MustEncodeSet("/x", "a", "")}
 	st.Ops <- Op{2, MustEncodeSet("/y", "b", "")}
 
-	g := st.SyncPath("/y")
+	g, err := st.SyncPath("/y")
+	assert.Equal(t, nil, err)
 	got := GetString(g, "/y")
 	assert.Equal(t, "b", got)
 }
 
+func TestSyncPathBad(t *testing.T) {
+	st := New()
+	for _, p := range []string{"x", "/x/", "/x*"} {
+		_, err := st.SyncPath(p)
+		assert.Equal(t, &BadPathError{p}, err, p)
+	}
+}
+
+func TestWatchBad(t *testing.T) {
+	st := New()
+	ch, err := st.Watch("x")
+	assert.Equal(t, (<-chan Event)(nil), ch)
+	assert.NotEqual(t, nil, err)
+}
+
 func TestGetDirAndWatch(t *testing.T) {
 	st := New()
 	st.Ops <- Op{1, MustEncodeSet("/x/a", "1", Clobber)}
 	st.Sync(1)
 
 	ch := make(chan Event, 100)
-	st.GetDirAndWatch("/x", ch)
+	err := st.GetDirAndWatch("/x", ch)
+	assert.Equal(t, nil, err)
 
 	mut2 := MustEncodeSet("/x/b", "2", Clobber)
 	mut4 := MustEncodeSet("/x/c", "3", Clobber)
@@ -1062,9 +1138,15 @@ func TestGetDirAndWatch(t *testing.T) {
 	assert.Equal(t, Event{4, "/x/c", "3", "4", mut4, nil, nil}, clearGetter(<-ch))
 }
 
+func TestGetDirAndWatchBadPath(t *testing.T) {
+	st := New()
+	err := st.GetDirAndWatch("x", make(chan Event))
+	assert.Equal(t, &BadPathError{"x"}, err)
+}
+
 func TestStoreClose(t *testing.T) {
 	st := New()
-	ch := st.Watch("/a/b/c")
+	ch := st.MustWatch("/a/b/c")
 	close(st.Ops)
 	assert.Equal(t, Event{}, <-ch)
 	assert.T(t, closed(ch))
@@ -1100,7 +1182,7 @@ func TestStoreSeqn(t *testing.T) {
 
 func TestStoreNoDeadlock(t *testing.T) {
 	st := New()
-	st.Watch("**")
+	st.MustWatch("**")
 	st.Ops <- Op{1, Nop}
 	<-st.Seqns
 }
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Returns a mutation that can be applied to a `Store`. The mutation will
+// delete the file or directory at `path`, along with everything below it, iff
+// `cas` matches at the time of application. For a directory, `cas` is
+// compared to the CAS token of its newest descendant (see Stat).
+//
+// If `path` is not valid, returns a `BadPathError`.
+func EncodeDelTree(path, cas string) (mut string, err os.Error) {
+	if err = checkPath(path); err != nil {
+		return
+	}
+	return mutation{kind: mutDelTree, cas: cas, paths: []string{path}}.encode(), nil
+}
+
+// Returns a mutation that can be applied to a `Store`. The mutation will move
+// the file or directory at `from`, along with everything below it, to `to`,
+// iff `cas` matches `from` as for EncodeDelTree. Nothing may exist at `to`.
+//
+// Every moved file is given a new CAS token, as if it had just been created.
+//
+// If either path is not valid, returns a `BadPathError`.
+func EncodeMove(from, to, cas string) (mut string, err os.Error) {
+	if err = checkPath(from); err != nil {
+		return
+	}
+	if err = checkPath(to); err != nil {
+		return
+	}
+	return mutation{kind: mutMove, cas: cas, paths: []string{from, to}}.encode(), nil
+}
+
+// Returns the token that a tree operation's cas is compared against.
+func (n node) treeCas(path string) string {
+	m, ok := n.lookup(split(path))
+	switch {
+	case !ok:
+		return Missing
+	case m.cas == Dir:
+		return strconv.Uitoa64(m.newest())
+	}
+	return m.cas
+}
+
+// Returns os.ENOTDIR if any parent of `path` is a file.
+func (n node) checkParents(path string) os.Error {
+	components := split(path)
+	for i := 0; i < len(components)-1; i++ {
+		_, dirCas := n.get(components[0 : i+1])
+		if dirCas == Missing {
+			break
+		}
+		if dirCas != Dir {
+			return os.ENOTDIR
+		}
+	}
+	return nil
+}
+
+func (n node) applyTree(seqn uint64, kind int, paths []string, cas string) (rep node, err os.Error) {
+	from := paths[0]
+	if from == "/" {
+		return n, os.EINVAL
+	}
+
+	if cas != Clobber && cas != n.treeCas(from) {
+		return n, ErrCasMismatch
+	}
+
+	m, ok := n.lookup(split(from))
+	rep = n.setp(from, "", Missing, seqn, false)
+
+	if kind == mutMove {
+		to := paths[1]
+		switch {
+		case !ok:
+			return n, os.ENOENT
+		case to == from || strings.HasPrefix(to, from+"/"):
+			return n, os.EINVAL
+		}
+
+		if _, toCas := rep.Get(to); toCas != Missing {
+			return n, os.EEXIST
+		}
+
+		if err = rep.checkParents(to); err != nil {
+			return n, err
+		}
+
+		rep = rep.put(split(to), m.restamp(seqn))
+	}
+
+	return rep, nil
+}
+
+// Returns a copy of `n` in which every file looks as if it had been created
+// at `seqn`.
+func (n node) restamp(seqn uint64) node {
+	if n.cas != Dir {
+		return node{n.v, strconv.Uitoa64(seqn), nil, seqn, 1}
+	}
+
+	ds := make(map[string]node)
+	for name, m := range n.ds {
+		ds[name] = m.restamp(seqn)
+	}
+	return node{n.v, Dir, ds, 0, 0}
+}
+
+// Calls `f` for every file at or below `path`.
+func (n node) walkFiles(path string, f func(string, node)) {
+	if m, ok := n.lookup(split(path)); ok {
+		m.eachFile(path, f)
+	}
+}
+
+func (n node) eachFile(path string, f func(string, node)) {
+	if n.cas != Dir {
+		f(path, n)
+		return
+	}
+
+	if path == "/" {
+		path = ""
+	}
+
+	for name, m := range n.ds {
+		m.eachFile(path+"/"+name, f)
+	}
+}
+
+// Returns the events that watchers should see for `ev`, which was produced
+// by applying a mutation to `before`. A tree operation produces one event per
+// file it affects; anything else produces just `ev`.
+func fanout(before node, ev Event) []Event {
+	if ev.Err != nil {
+		return []Event{ev}
+	}
+
+	m, err := decodeMut(ev.Mut)
+	if err != nil || (m.kind != mutDelTree && m.kind != mutMove) {
+		return []Event{ev}
+	}
+	paths := m.paths
+
+	var evs []Event
+	before.walkFiles(paths[0], func(path string, _ node) {
+		evs = append(evs, Event{ev.Seqn, path, "", Missing, ev.Mut, nil, ev.Getter})
+	})
+
+	if m.kind == mutMove {
+		after := ev.Getter.(node)
+		after.walkFiles(paths[1], func(path string, m node) {
+			evs = append(evs, Event{ev.Seqn, path, m.v, m.cas, ev.Mut, nil, ev.Getter})
+		})
+	}
+
+	if len(evs) == 0 {
+		return []Event{ev}
+	}
+	return evs
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"github.com/bmizerany/assert"
+	"os"
+	"testing"
+)
+
+func TestEncodeDelTree(t *testing.T) {
+	m, err := EncodeDelTree("/x", "5")
+	assert.Equal(t, nil, err)
+	got, err := decodeMut(m)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mutation{mutDelTree, "5", []string{"/x"}, ""}, got)
+
+	_, err = EncodeDelTree("x", Clobber)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestEncodeMove(t *testing.T) {
+	m, err := EncodeMove("/x", "/y", Clobber)
+	assert.Equal(t, nil, err)
+	got, err := decodeMut(m)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mutation{mutMove, Clobber, []string{"/x", "/y"}, ""}, got)
+
+	_, err = EncodeMove("/x", "y", Clobber)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestDecodeTextNoTree(t *testing.T) {
+	// a plain delete whose cas happens to be "rmr"
+	m, err := decodeMut("rmr:/x")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mutation{mutDel, "rmr", []string{"/x"}, ""}, m)
+}
+
+func TestNodeApplyDelTree(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/d/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/d/e/y", "b", Clobber))
+	r, _ = r.apply(3, MustEncodeSet("/z", "c", Clobber))
+
+	m, _ := EncodeDelTree("/d", "2")
+	n, e := r.apply(4, m)
+	assert.Equal(t, nil, e.Err)
+	assert.Equal(t, "/d", e.Path)
+	assert.T(t, e.IsDel())
+	assert.Equal(t, []string{"z"}, GetDir(n, "/"))
+}
+
+func TestNodeApplyDelTreeCasMismatch(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/d/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/d/y", "b", Clobber))
+
+	m, _ := EncodeDelTree("/d", "1")
+	n, e := r.apply(3, m)
+	assert.Equal(t, ErrCasMismatch, e.Err)
+	assert.Equal(t, ErrorPath, e.Path)
+	assert.Equal(t, 2, len(GetDir(n, "/d")))
+}
+
+func TestNodeApplyDelTreeRoot(t *testing.T) {
+	m, _ := EncodeDelTree("/", Clobber)
+	_, e := emptyDir.apply(1, m)
+	assert.Equal(t, os.EINVAL, e.Err)
+}
+
+func TestNodeApplyMove(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/d/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/d/e/y", "b", Clobber))
+
+	m, _ := EncodeMove("/d", "/f/g", Clobber)
+	n, e := r.apply(3, m)
+	assert.Equal(t, nil, e.Err)
+
+	_, cas := n.Get("/d")
+	assert.Equal(t, Missing, cas)
+
+	v, cas := n.Get("/f/g/e/y")
+	assert.Equal(t, []string{"b"}, v)
+	assert.Equal(t, "3", cas)
+
+	s, _ := n.Stat("/f/g/x")
+	assert.Equal(t, Stat{Created: 3, Modified: 3, Version: 1, Len: 1}, s)
+}
+
+func TestNodeApplyMoveErrors(t *testing.T) {
+	r, _ := emptyDir.apply(1, MustEncodeSet("/d/x", "a", Clobber))
+	r, _ = r.apply(2, MustEncodeSet("/f", "b", Clobber))
+
+	cases := []struct {
+		from, to string
+		err      os.Error
+	}{
+		{"/nope", "/y", os.ENOENT},
+		{"/d", "/f", os.EEXIST},
+		{"/d", "/f/y", os.ENOTDIR},
+		{"/d", "/d/y", os.EINVAL},
+	}
+
+	for _, c := range cases {
+		m, _ := EncodeMove(c.from, c.to, Clobber)
+		_, e := r.apply(3, m)
+		assert.Equal(t, c.err, e.Err, c.from+" "+c.to)
+	}
+}
+
+func TestStoreDelTreeNotifies(t *testing.T) {
+	st := New()
+	ch := st.MustWatch("/d/**")
+	defer close(ch)
+
+	st.Ops <- Op{1, MustEncodeSet("/d/x", "a", Clobber)}
+	st.Ops <- Op{2, MustEncodeSet("/d/y", "b", Clobber)}
+	<-ch
+	<-ch
+
+	m, _ := EncodeDelTree("/d", Clobber)
+	st.Ops <- Op{3, m}
+
+	paths := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		ev := <-ch
+		assert.T(t, ev.IsDel())
+		assert.Equal(t, uint64(3), ev.Seqn)
+		paths[ev.Path] = true
+	}
+	assert.Equal(t, map[string]bool{"/d/x": true, "/d/y": true}, paths)
+}
+
+func TestStoreMoveNotifies(t *testing.T) {
+	st := New()
+	ch := st.MustWatch("/**")
+	defer close(ch)
+
+	st.Ops <- Op{1, MustEncodeSet("/a/x", "a", Clobber)}
+	<-ch
+
+	m, _ := EncodeMove("/a", "/b", Clobber)
+	st.Ops <- Op{2, m}
+
+	ev := <-ch
+	assert.Equal(t, "/a/x", ev.Path)
+	assert.T(t, ev.IsDel())
+
+	ev = <-ch
+	assert.Equal(t, "/b/x", ev.Path)
+	assert.Equal(t, "a", ev.Body)
+	assert.Equal(t, "2", ev.Cas)
+}
+
+func TestStoreDelTreeMissingWait(t *testing.T) {
+	st := New()
+	m, _ := EncodeDelTree("/d", Clobber)
+	st.Ops <- Op{1, m}
+	st.Sync(1)
+}
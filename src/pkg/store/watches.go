@@ -0,0 +1,177 @@
+package store
+
+// Watches are indexed in a trie by the literal components at the start of
+// each of their globs, up to the first component with a wildcard. To find
+// the watches that might want an event, we walk the trie along the event's
+// path and collect the watches at each node we pass. Only those are matched
+// against the event, so the cost of an event depends on how many watches
+// are near its path, not on how many watches there are in total.
+//
+// A watch with more than one glob may appear at several nodes.
+type watchNode struct {
+	ws []*watch
+	ds map[string]*watchNode
+}
+
+type watchIndex struct {
+	root  *watchNode
+	n     int // number of distinct watches
+	added int // number added since the last sweep
+	swept int // value of n after the last sweep
+}
+
+func newWatchNode() *watchNode {
+	return &watchNode{ds: make(map[string]*watchNode)}
+}
+
+func newWatchIndex() *watchIndex {
+	return &watchIndex{root: newWatchNode()}
+}
+
+// Returns the leading components of `pattern` that have no wildcards.
+func globPrefix(pattern string) []string {
+	if len(pattern) == 0 || pattern[0] != '/' {
+		return []string{}
+	}
+
+	parts := split(pattern)
+	for i, c := range parts {
+		for _, r := range c {
+			if r == '*' || r == '?' {
+				return parts[0:i]
+			}
+		}
+	}
+	return parts
+}
+
+func (x *watchIndex) add(w *watch) {
+	for _, parts := range w.f.prefixes {
+		n := x.root
+		for _, c := range parts {
+			d, ok := n.ds[c]
+			if !ok {
+				d = newWatchNode()
+				n.ds[c] = d
+			}
+			n = d
+		}
+		n.ws = append(n.ws, w)
+	}
+	x.n++
+
+	// Closed watches are normally dropped when an event reaches them, but
+	// some never see another event. Sweep them up once in a while; doing it
+	// only after as many additions as there were watches at the last sweep
+	// keeps the cost constant per addition.
+	x.added++
+	if x.added > x.swept {
+		x.sweep()
+	}
+}
+
+func without(ws []*watch, w *watch) []*watch {
+	i := 0
+	for _, v := range ws {
+		if v != w {
+			ws[i] = v
+			i++
+		}
+	}
+	return ws[0:i]
+}
+
+// Removes `w` from the node at `parts` below `n`. Returns true iff `n` is
+// left with nothing in it.
+func (n *watchNode) remove(parts []string, w *watch) bool {
+	if len(parts) == 0 {
+		n.ws = without(n.ws, w)
+	} else if d, ok := n.ds[parts[0]]; ok && d.remove(parts[1:], w) {
+		n.ds[parts[0]] = nil, false
+	}
+	return len(n.ws) == 0 && len(n.ds) == 0
+}
+
+func (x *watchIndex) remove(w *watch) {
+	for _, parts := range w.f.prefixes {
+		x.root.remove(parts, w)
+	}
+	x.n--
+}
+
+// Calls `f` once for each watch in the index.
+func (x *watchIndex) each(f func(*watch)) {
+	seen := make(map[*watch]bool)
+	x.root.each(func(w *watch) {
+		if !seen[w] {
+			seen[w] = true
+			f(w)
+		}
+	})
+}
+
+func (n *watchNode) each(f func(*watch)) {
+	for _, w := range n.ws {
+		f(w)
+	}
+	for _, d := range n.ds {
+		d.each(f)
+	}
+}
+
+// Removes all closed watches.
+func (x *watchIndex) sweep() {
+	var dead []*watch
+	x.each(func(w *watch) {
+		if closed(w.out) {
+			dead = append(dead, w)
+		}
+	})
+
+	for _, w := range dead {
+		x.remove(w)
+	}
+	x.added, x.swept = 0, x.n
+}
+
+// Returns the open watches that might match an event at `path`. Any closed
+// watches found along the way are removed.
+func (x *watchIndex) lookup(path string) []*watch {
+	var parts []string
+	if len(path) > 0 {
+		parts = split(path)
+	}
+
+	var ws, dead []*watch
+	seen := make(map[*watch]bool)
+	visit := func(n *watchNode) {
+		for _, w := range n.ws {
+			switch {
+			case seen[w]:
+				// already have it
+			case closed(w.out):
+				dead = append(dead, w)
+			default:
+				ws = append(ws, w)
+			}
+			seen[w] = true
+		}
+	}
+
+	n := x.root
+	visit(n)
+	for _, c := range parts {
+		d, ok := n.ds[c]
+		if !ok {
+			break
+		}
+		n = d
+		visit(n)
+	}
+
+	for _, w := range dead {
+		x.remove(w)
+	}
+
+	return ws
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+var globPrefixes = [][]string{
+	{"**"},
+	{"/"},
+	{"/**"},
+	{"/a", "a"},
+	{"/a/b", "a", "b"},
+	{"/a/*", "a"},
+	{"/a/b?/c", "a"},
+	{"/a**"},
+	{"/a/**/b", "a"},
+}
+
+func TestGlobPrefix(t *testing.T) {
+	for _, p := range globPrefixes {
+		assert.Equal(t, p[1:], globPrefix(p[0]), p[0])
+	}
+}
+
+func newTestWatch(globs ...string) *watch {
+	return &watch{out: make(chan Event, 1), f: mustFilter(Filter{Globs: globs})}
+}
+
+func TestWatchIndexLookup(t *testing.T) {
+	x := newWatchIndex()
+	all, a, ab, c := newTestWatch("**"), newTestWatch("/a/*"), newTestWatch("/a/b"), newTestWatch("/c/*")
+	x.add(all)
+	x.add(a)
+	x.add(ab)
+	x.add(c)
+	assert.Equal(t, 4, x.n)
+
+	assert.Equal(t, []*watch{all, a, ab}, x.lookup("/a/b"))
+	assert.Equal(t, []*watch{all, c}, x.lookup("/c/d"))
+	assert.Equal(t, []*watch{all}, x.lookup("/d"))
+	assert.Equal(t, []*watch{all}, x.lookup(""))
+}
+
+func TestWatchIndexMultipleGlobs(t *testing.T) {
+	x := newWatchIndex()
+	w := newTestWatch("/a/*", "/a/b/*")
+	x.add(w)
+	assert.Equal(t, 1, x.n)
+	assert.Equal(t, []*watch{w}, x.lookup("/a/b/c"))
+}
+
+func TestWatchIndexRemove(t *testing.T) {
+	x := newWatchIndex()
+	w := newTestWatch("/a/b/*", "/c")
+	x.add(w)
+	x.remove(w)
+	assert.Equal(t, 0, x.n)
+	assert.Equal(t, 0, len(x.root.ds))
+}
+
+func TestWatchIndexLookupDropsClosed(t *testing.T) {
+	x := newWatchIndex()
+	w := newTestWatch("/a/*")
+	x.add(w)
+
+	close(w.out)
+	<-w.out
+
+	assert.Equal(t, 0, len(x.lookup("/a/b")))
+	assert.Equal(t, 0, x.n)
+	assert.Equal(t, 0, len(x.root.ds))
+}
+
+func TestWatchIndexSweep(t *testing.T) {
+	x := newWatchIndex()
+	w := newTestWatch("/a/*")
+	x.add(w)
+
+	close(w.out)
+	<-w.out
+
+	// Adding enough new watches eventually sweeps up the closed one, even
+	// though no event ever reaches it.
+	x.add(newTestWatch("/b"))
+	x.add(newTestWatch("/c"))
+	x.add(newTestWatch("/d"))
+	assert.Equal(t, 3, x.n)
+	_, ok := x.root.ds["a"]
+	assert.Equal(t, false, ok)
+}
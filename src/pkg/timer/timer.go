@@ -5,8 +5,8 @@ import (
 	"container/vector"
 	"doozer/store"
 	"doozer/util"
-	"math"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -21,11 +21,23 @@ type Tick struct {
 	At   int64
 }
 
+// A pending tick. Ticks due at the same time are sent in the order they
+// were set.
+type entry struct {
+	Tick
+	seqn uint64
+}
 
-func (t Tick) Less(y interface{}) bool {
-	return t.At < y.(Tick).At
+func (x entry) Less(y interface{}) bool {
+	if x.At == y.(entry).At {
+		return x.seqn < y.(entry).seqn
+	}
+	return x.At < y.(entry).At
 }
 
+// A Timer watches files matching Pattern. The body of each is a time, in ns,
+// at which a Tick is sent on C. Setting a file again moves its tick, and
+// deleting it cancels the tick.
 type Timer struct {
 	Pattern string
 
@@ -35,17 +47,23 @@ type Timer struct {
 	events <-chan store.Event
 
 	ticks  *vector.Vector
-	ticker *time.Ticker
+	wake   chan bool
+	wakeAt int64 // when a sleeping goroutine will send on wake, if any
+	stop   chan bool
+	once   sync.Once
+	logger *util.Logger
 }
 
-func New(pattern string, interval int64, st *store.Store) *Timer {
+func New(pattern string, st *store.Store) *Timer {
 	c := make(chan Tick)
 	t := &Timer{
 		Pattern: pattern,
 		C:       c,
-		events:  st.Watch(pattern),
+		events:  st.MustWatch(pattern),
 		ticks:   new(vector.Vector),
-		ticker:  time.NewTicker(interval),
+		wake:    make(chan bool),
+		stop:    make(chan bool),
+		logger:  util.NewLogger("timer").With("pattern", pattern),
 	}
 
 	go t.process(c)
@@ -56,67 +74,88 @@ func New(pattern string, interval int64, st *store.Store) *Timer {
 func (t *Timer) process(c chan Tick) {
 	defer close(c)
 
-	defer t.ticker.Stop()
-
-	logger := util.NewLogger("timer (%s)", t.Pattern)
-
-	peek := func() Tick {
-		if t.ticks.Len() == 0 {
-			return Tick{At: math.MaxInt64}
-		}
-		return t.ticks.At(0).(Tick)
-	}
-
 	for {
+		t.schedule()
+
 		select {
 		case e := <-t.events:
 			if closed(t.events) {
 				return
 			}
 
-			logger.Printf("recvd: %v", e)
-			// TODO: Handle/Log the next error
-			// I'm not sure if we should notify the client
-			// on Set.  That seems like it would be difficult
-			// with the currect way the code functions.  Dunno.
-			at, _ := strconv.Atoi64(e.Body)
-
-			x := Tick{e.Path, at}
-			switch {
-			default:
+			t.logger.Debug("recvd", "path", e.Path, "body", e.Body)
+			t.remove(e.Path)
+			if !e.IsSet() {
 				break
-			case e.IsSet():
-				// First remove it if it's already there.
-				for i := 0; i < t.ticks.Len(); i++ {
-					if t.ticks.At(i).(Tick).Path == x.Path {
-						heap.Remove(t.ticks, i)
-						i = 0 // have to start over; heap could be reordered
-					}
-				}
+			}
 
-				heap.Push(t.ticks, x)
-			case e.IsDel():
-				logger.Println("deleting", e.Path, e.Body)
-				// This could be optimize since t.ticks is sorted; I can't
-				// find a way without implementing our own quick-find.
-				for i := 0; i < t.ticks.Len(); i++ {
-					if t.ticks.At(i).(Tick).Path == x.Path {
-						heap.Remove(t.ticks, i)
-						i = 0 // have to start over; heap could be reordered
-					}
+			at, err := strconv.Atoi64(e.Body)
+			if err != nil {
+				t.logger.Warn("bad time, ignoring", "path", e.Path, "body", e.Body)
+				break
+			}
+			heap.Push(t.ticks, entry{Tick{e.Path, at}, e.Seqn})
+
+		case <-t.wake:
+			t.wakeAt = 0
+			for t.ticks.Len() > 0 && t.ticks.At(0).(entry).At <= time.Nanoseconds() {
+				next := heap.Pop(t.ticks).(entry).Tick
+				t.logger.Debug("ticked", "path", next.Path, "at", next.At)
+				select {
+				case c <- next:
+				case <-t.stop:
+					return
 				}
 			}
 
-		case ns := <-t.ticker.C:
-			for next := peek(); next.At <= ns; next = peek() {
-				logger.Printf("ticked %#v", next)
-				heap.Pop(t.ticks)
-				c <- next
-			}
+		case <-t.stop:
+			return
 		}
 	}
 }
 
+// Makes sure a goroutine will wake us when the first tick is due. One
+// already sleeping until then, or sooner, will do.
+func (t *Timer) schedule() {
+	if t.ticks.Len() == 0 {
+		return
+	}
+
+	at := t.ticks.At(0).(entry).At
+	if t.wakeAt != 0 && t.wakeAt <= at {
+		return
+	}
+
+	t.wakeAt = at
+	go func() {
+		time.Sleep(at - time.Nanoseconds())
+		select {
+		case t.wake <- true:
+		case <-t.stop:
+		}
+	}()
+}
+
+// Cancels the tick for `path`, if there is one.
+func (t *Timer) remove(path string) {
+	for i := 0; i < t.ticks.Len(); i++ {
+		if t.ticks.At(i).(entry).Path == path {
+			heap.Remove(t.ticks, i)
+			return // there is at most one
+		}
+	}
+}
+
+// Stops the timer. No more ticks are sent, and C is closed. It is safe to
+// call more than once.
+func (t *Timer) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+		close(t.events)
+	})
+}
+
+// Close is the same as Stop.
 func (t *Timer) Close() {
-	close(t.events)
+	t.Stop()
 }
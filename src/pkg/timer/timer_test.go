@@ -24,7 +24,7 @@ func encodeTimer(path string, offset int64) string {
 
 func TestManyOneshotTimers(t *testing.T) {
 	st := store.New()
-	timer := New(testPattern, 10*OneMillisecond, st)
+	timer := New(testPattern, st)
 	defer timer.Close()
 
 	st.Ops <- store.Op{1, encodeTimer("/timer/longest", 40*OneMillisecond)}
@@ -48,7 +48,7 @@ func TestManyOneshotTimers(t *testing.T) {
 
 func TestDeleteTimer(t *testing.T) {
 	st := store.New()
-	timer := New(testPattern, OneMillisecond, st)
+	timer := New(testPattern, st)
 	defer timer.Close()
 
 	never := "/timer/never/ticks"
@@ -68,7 +68,7 @@ func TestDeleteTimer(t *testing.T) {
 
 func TestUpdate(t *testing.T) {
 	st := store.New()
-	timer := New(testPattern, OneMillisecond, st)
+	timer := New(testPattern, st)
 	defer timer.Close()
 
 	st.Ops <- store.Op{1, encodeTimer("/timer/y", 90*OneMillisecond)}
@@ -81,3 +81,59 @@ func TestUpdate(t *testing.T) {
 	assert.Equal(t, "/timer/x", (<-timer.C).Path) // From seqn 3
 	assert.Equal(t, "/timer/y", (<-timer.C).Path) // From seqn 1
 }
+
+func TestSameTime(t *testing.T) {
+	st := store.New()
+	timer := New(testPattern, st)
+	defer timer.Stop()
+
+	at := strconv.Itoa64(time.Nanoseconds() + 10*OneMillisecond)
+	st.Ops <- store.Op{1, store.MustEncodeSet("/timer/b", at, store.Clobber)}
+	st.Ops <- store.Op{2, store.MustEncodeSet("/timer/a", at, store.Clobber)}
+
+	// Ticks due at once come in the order they were set.
+	assert.Equal(t, "/timer/b", (<-timer.C).Path)
+	assert.Equal(t, "/timer/a", (<-timer.C).Path)
+}
+
+func TestPrecise(t *testing.T) {
+	st := store.New()
+	timer := New(testPattern, st)
+	defer timer.Stop()
+
+	st.Ops <- store.Op{1, encodeTimer("/timer/y", 40*OneMillisecond)}
+	st.Ops <- store.Op{2, encodeTimer("/timer/x", 20*OneMillisecond)}
+
+	// Ticks come in order and never early. How late depends on how busy the
+	// machine is, so allow plenty.
+	for _, p := range []string{"/timer/x", "/timer/y"} {
+		got := <-timer.C
+		late := time.Nanoseconds() - got.At
+		assert.Equal(t, p, got.Path)
+		assert.T(t, late >= 0, late)
+		assert.T(t, late < 1000*OneMillisecond, late)
+	}
+}
+
+func TestBadTime(t *testing.T) {
+	st := store.New()
+	timer := New(testPattern, st)
+	defer timer.Stop()
+
+	st.Ops <- store.Op{1, store.MustEncodeSet("/timer/x", "soon", store.Clobber)}
+	st.Ops <- store.Op{2, encodeTimer("/timer/y", OneMillisecond)}
+	assert.Equal(t, "/timer/y", (<-timer.C).Path)
+}
+
+func TestStop(t *testing.T) {
+	st := store.New()
+	timer := New(testPattern, st)
+
+	st.Ops <- store.Op{1, encodeTimer("/timer/x", OneMillisecond)}
+	timer.Stop()
+	timer.Stop() // again is fine
+
+	for _ = range timer.C {
+	}
+	assert.T(t, closed(timer.C))
+}
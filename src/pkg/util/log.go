@@ -0,0 +1,170 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Level int
+
+// Log levels, from most to least verbose.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// Returns the level named `s`, which is one of "debug", "info", "warn" or
+// "error", ignoring case and surrounding space.
+func ParseLevel(s string) (Level, os.Error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+	return 0, os.NewError("unknown log level: " + s)
+}
+
+// The level of each subsystem. A subsystem not listed logs at defaultLevel.
+var (
+	levels       = make(map[string]Level)
+	defaultLevel = Info
+	levelLock    sync.RWMutex
+)
+
+// Sets the level of subsystem `sub`. Messages below this level are dropped.
+func SetLevel(sub string, l Level) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	levels[sub] = l
+}
+
+// Makes subsystem `sub` log at the default level again.
+func ResetLevel(sub string) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	levels[sub] = 0, false
+}
+
+// Sets the level of every subsystem without a level of its own.
+func SetDefaultLevel(l Level) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	defaultLevel = l
+}
+
+// Returns the level that subsystem `sub` logs at.
+func LevelOf(sub string) Level {
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	if l, ok := levels[sub]; ok {
+		return l
+	}
+	return defaultLevel
+}
+
+// Writes levelled log lines for one subsystem to LogWriter, in the form
+//
+//   doozerd: <subsystem> <level> <message> key=value ...
+//
+// Each line carries the logger's own fields, followed by those given in
+// the call. Which lines are written depends on the subsystem's current
+// level; see SetLevel.
+type Logger struct {
+	sub    string
+	fields []interface{}
+	out    *log.Logger
+}
+
+func NewLogger(sub string) *Logger {
+	if sub == "" {
+		panic("always give a subsystem!")
+	}
+	out := log.New(logWriter{}, "doozerd: ", log.Lshortfile|log.Lmicroseconds)
+	return &Logger{sub: sub, out: out}
+}
+
+// Writes to whatever LogWriter is at the time, so that loggers made before
+// LogWriter is set still end up there.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, os.Error) {
+	return LogWriter.Write(p)
+}
+
+// Returns a logger for the same subsystem that adds `kv`, a list of
+// alternating keys and values, to each line.
+func (lg *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, len(lg.fields), len(lg.fields)+len(kv))
+	copy(fields, lg.fields)
+	return &Logger{lg.sub, append(fields, kv...), lg.out}
+}
+
+func (lg *Logger) Debug(msg string, kv ...interface{}) {
+	lg.log(Debug, msg, kv)
+}
+
+func (lg *Logger) Info(msg string, kv ...interface{}) {
+	lg.log(Info, msg, kv)
+}
+
+func (lg *Logger) Warn(msg string, kv ...interface{}) {
+	lg.log(Warn, msg, kv)
+}
+
+func (lg *Logger) Error(msg string, kv ...interface{}) {
+	lg.log(Error, msg, kv)
+}
+
+// Logs at level Info, like log.Logger.Println.
+func (lg *Logger) Println(v ...interface{}) {
+	lg.log(Info, strings.TrimRight(fmt.Sprintln(v...), "\n"), nil)
+}
+
+// Logs at level Info, like log.Logger.Printf.
+func (lg *Logger) Printf(format string, v ...interface{}) {
+	lg.log(Info, fmt.Sprintf(format, v...), nil)
+}
+
+func (lg *Logger) log(l Level, msg string, kv []interface{}) {
+	if l < LevelOf(lg.sub) {
+		return
+	}
+
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "%s %s %s", lg.sub, l, msg)
+	writeFields(b, lg.fields)
+	writeFields(b, kv)
+
+	lg.out.Output(3, b.String())
+}
+
+func writeFields(b *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		var v interface{} = "(missing)"
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+
+		s := fmt.Sprint(v)
+		if s == "" || strings.IndexAny(s, " \t\n\"=") >= 0 {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(b, " %v=%s", kv[i], s)
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"bytes"
+	"github.com/bmizerany/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func captureLog(f func()) string {
+	b := new(bytes.Buffer)
+	defer func(w io.Writer) { LogWriter = w }(LogWriter)
+	LogWriter = b
+	f()
+	return b.String()
+}
+
+func TestParseLevel(t *testing.T) {
+	l, err := ParseLevel(" Warn\n")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Warn, l)
+
+	_, err = ParseLevel("loud")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestLevelString(t *testing.T) {
+	assert.Equal(t, "debug", Debug.String())
+	assert.Equal(t, "error", Error.String())
+	assert.Equal(t, "level(9)", Level(9).String())
+}
+
+func TestLoggerFormat(t *testing.T) {
+	lg := NewLogger("test-format").With("addr", "1.2.3.4:5")
+	got := captureLog(func() {
+		lg.Info("hello", "n", 1, "s", "a b")
+	})
+	assert.T(t, strings.HasPrefix(got, "doozerd: "), got)
+	assert.T(t, strings.HasSuffix(got, `test-format info hello addr=1.2.3.4:5 n=1 s="a b"`+"\n"), got)
+}
+
+func TestLoggerWithCopies(t *testing.T) {
+	lg := NewLogger("test-with")
+	a := lg.With("a", 1)
+	b := a.With("b", 2)
+	c := a.With("c", 3)
+	got := captureLog(func() {
+		b.Info("x")
+		c.Info("y")
+	})
+	assert.T(t, strings.Contains(got, "x a=1 b=2\n"), got)
+	assert.T(t, strings.Contains(got, "y a=1 c=3\n"), got)
+}
+
+func TestLoggerMissingValue(t *testing.T) {
+	got := captureLog(func() {
+		NewLogger("test-missing").Info("x", "k")
+	})
+	assert.T(t, strings.Contains(got, "x k=(missing)\n"), got)
+}
+
+func TestLoggerLevels(t *testing.T) {
+	lg := NewLogger("test-levels")
+	defer ResetLevel("test-levels")
+
+	got := captureLog(func() {
+		lg.Debug("a")
+		lg.Info("b")
+	})
+	assert.T(t, !strings.Contains(got, " a"), got)
+	assert.T(t, strings.Contains(got, "info b"), got)
+
+	SetLevel("test-levels", Debug)
+	got = captureLog(func() { lg.Debug("c") })
+	assert.T(t, strings.Contains(got, "debug c"), got)
+
+	SetLevel("test-levels", Error)
+	got = captureLog(func() { lg.Warn("d") })
+	assert.Equal(t, "", got)
+
+	ResetLevel("test-levels")
+	assert.Equal(t, Info, LevelOf("test-levels"))
+}
+
+func TestDefaultLevel(t *testing.T) {
+	defer SetDefaultLevel(Info)
+	SetLevel("test-own", Info)
+	defer ResetLevel("test-own")
+
+	SetDefaultLevel(Error)
+	assert.Equal(t, Error, LevelOf("test-default"))
+	assert.Equal(t, Info, LevelOf("test-own"))
+}
+
+func TestLoggerPrintln(t *testing.T) {
+	got := captureLog(func() {
+		NewLogger("test-println").Println("a", 1)
+	})
+	assert.T(t, strings.Contains(got, "test-println info a 1\n"), got)
+}
@@ -3,9 +3,7 @@ package util
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"strings"
 )
 
 // Sufficient for 10**6 simultaneous IDs with probability of collision less
@@ -64,20 +62,6 @@ func Unpackui64(b []byte) (n uint64) {
 	return
 }
 
-func NewLogger(format string, a ...interface{}) *log.Logger {
-	prefix := fmt.Sprintf(format, a...)
-
-	if prefix == "" {
-		panic("always give a prefix!")
-	}
-
-	if strings.HasPrefix(prefix, "udp") {
-		return log.New(NullWriter{}, "", log.Lshortfile)
-	}
-
-	return log.New(LogWriter, "doozerd: "+prefix+" ", log.Lshortfile | log.Lmicroseconds)
-}
-
 func RandHexString(bits int) string {
 	buf := make([]byte, bits/8)
 	RandBytes(buf)
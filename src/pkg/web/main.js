@@ -17,6 +17,19 @@ function insert(parent, child) {
   }
 }
 
+// Path components are escaped as in a URL; see store.Escape.
+function display(part) {
+  return decodeURIComponent(part);
+}
+
+function urlpath(p) {
+  return $.map(p.split('/'), encodeURIComponent).join('/');
+}
+
+function named(jq, name) {
+  return jq.filter(function () { return $(this).attr('name') === name });
+}
+
 function apply(ev) {
   var parts = ev.Path.split("/")
   if (parts.length < 2) {
@@ -27,11 +40,11 @@ function apply(ev) {
   var dir = $('#root');
   for (var i = 0; i < dir_parts.length; i++) {
     var part = dir_parts[i];
-    var next = dir.find('> dl > div[name="'+part+'"] > dd');
+    var next = named(dir.find('> dl > div'), part).children('dd');
     if (next.length < 1) {
       var div = $('<div>').attr('name', part);
       var dd = $('<dd>');
-      div.append($('<dt>').text(part+'/')).append(dd);
+      div.append($('<dt>').text(display(part)+'/')).append(dd);
       insert(dir.children('dl'), div);
       dd.append('<dl>').append('<table><tbody>');
       next = dd;
@@ -40,11 +53,11 @@ function apply(ev) {
   }
 
   var basename = parts[parts.length - 1];
-  var entry = dir.find('tr[name="'+basename+'"]');
+  var entry = named(dir.find('tr'), basename);
   if (entry.length < 1) {
     var tr = $('<tr class=new>').attr('name', basename);
     insert(dir.children('table').children('tbody'), tr);
-    tr.append($('<th>').text(basename)).
+    tr.append($('<th>').text(display(basename))).
       append('<td class=cas>').
       append('<td class=eq>').
       append('<td class=body>');
@@ -87,7 +100,7 @@ function open() {
   var body = $('body');
   var status = $('#status');
   status.text("connecting");
-  var ws = new WebSocket("ws://"+location.host+"/events"+path);
+  var ws = new WebSocket("ws://"+location.host+"/events"+urlpath(path));
   ws.onmessage = function (ev) {
     var jev = JSON.parse(ev.data);
     apply(jev);
@@ -6,8 +6,8 @@ import (
 	"doozer/store"
 	"doozer/util"
 	"json"
-	"log"
 	"net"
+	"os"
 	"strings"
 	"template"
 	"websocket"
@@ -16,9 +16,11 @@ import (
 var Store *store.Store
 var ClusterName, evPrefix string
 var mainTpl = template.MustParse(main_html, nil)
+var logger = util.NewLogger("web")
 
 type info struct {
 	Path string
+	Json string // Path as a JavaScript string literal
 }
 
 type stringHandler struct {
@@ -44,13 +46,13 @@ func Serve(listener net.Listener) {
 	http.Serve(listener, nil)
 }
 
-func send(ws *websocket.Conn, path string, evs <-chan store.Event, logger *log.Logger) {
+func send(ws *websocket.Conn, path string, evs <-chan store.Event, logger *util.Logger) {
 	defer close(evs)
 	l := len(path) - 1
 	for ev := range evs {
 		ev.Getter = nil // don't marshal the entire snapshot
 		ev.Path = ev.Path[l:]
-		logger.Println("sending", ev)
+		logger.Debug("sending", "seqn", ev.Seqn, "path", ev.Path)
 		b, err := json.Marshal(ev)
 		if err != nil {
 			logger.Println(err)
@@ -66,11 +68,20 @@ func send(ws *websocket.Conn, path string, evs <-chan store.Event, logger *log.L
 
 func evServer(w http.ResponseWriter, r *http.Request) {
 	wevs := make(chan store.Event)
-	logger := util.NewLogger(w.RemoteAddr())
-	path := r.URL.Path[len(evPrefix):]
-	logger.Println("new", path)
+	lg := logger.With("addr", w.RemoteAddr())
+	path, err := storePath(r, evPrefix)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusBadRequest)
+		return
+	}
+	lg.Info("new", "path", path)
 
-	evs := Store.Watch(path + "**")
+	evs, err := Store.WatchFilter(store.Filter{Globs: []string{path + "**"}})
+	if err != nil {
+		lg.Warn("watch", "err", err)
+		http.Error(w, err.String(), http.StatusBadRequest)
+		return
+	}
 
 	// TODO convert store.Snapshot to json and use that
 	go func() {
@@ -79,8 +90,8 @@ func evServer(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	websocket.Handler(func(ws *websocket.Conn) {
-		send(ws, path, wevs, logger)
-		send(ws, path, evs, logger)
+		send(ws, path, wevs, lg)
+		send(ws, path, evs, lg)
 		ws.Close()
 	}).ServeHTTP(w, r)
 }
@@ -91,11 +102,49 @@ func viewHtml(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var x info
-	x.Path = r.URL.Path[len("/view"):]
+	var err os.Error
+	x.Path, err = storePath(r, "/view")
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	b, err := json.Marshal(x.Path)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	x.Json = string(b)
 	w.SetHeader("content-type", "text/html")
 	mainTpl.Execute(x, w)
 }
 
+// Returns the store path that follows `prefix` in the URL of `r`. The URL
+// path has one more layer of escaping than the store path, so each component
+// is unescaped on its own; otherwise a store escape such as %2F, sent as
+// %252F, would be taken for a separator.
+func storePath(r *http.Request, prefix string) (string, os.Error) {
+	raw := r.URL.RawPath
+	if i := strings.Index(raw, "?"); i >= 0 {
+		raw = raw[0:i]
+	}
+	if !strings.HasPrefix(raw, prefix) {
+		return "", os.NewError("bad path: " + raw)
+	}
+
+	parts := strings.Split(raw[len(prefix):], "/", -1)
+	for i, part := range parts {
+		c, err := http.URLUnescape(part)
+		if err != nil {
+			return "", err
+		}
+		if strings.Index(c, "/") >= 0 {
+			return "", os.NewError("bad path: " + raw)
+		}
+		parts[i] = c
+	}
+	return strings.Join(parts, "/"), nil
+}
+
 func walk(path string, st *store.Store, ch chan store.Event) {
 	for path != "/" && strings.HasSuffix(path, "/") {
 		// TODO generalize and factor this into pkg store.
@@ -1,6 +1,34 @@
 package web
 
-import "testing"
+import (
+	"github.com/bmizerany/assert"
+	"http"
+	"testing"
+)
+
+func request(rawPath string) *http.Request {
+	return &http.Request{URL: &http.URL{RawPath: rawPath}}
+}
 
 func TestFoo(t *testing.T) {
 }
+
+func TestStorePath(t *testing.T) {
+	// The store path /a%2Fb/c, as main.js writes it in a URL.
+	p, err := storePath(request("/view/a%252Fb/c/?x=1"), "/view")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "/a%2Fb/c/", p)
+
+	p, err = storePath(request("/view/x%3Ay/"), "/view")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "/x:y/", p)
+}
+
+func TestStorePathBad(t *testing.T) {
+	// A bare %2F would put a separator inside a component.
+	_, err := storePath(request("/view/a%2Fb/"), "/view")
+	assert.NotEqual(t, nil, err)
+
+	_, err = storePath(request("/view/a%zz/"), "/view")
+	assert.NotEqual(t, nil, err)
+}